@@ -23,6 +23,475 @@ CREATE TABLE products (
 	CONSTRAINT fk_category FOREIGN KEY (category_id) REFERENCES categories (category_id)
 );
 
+-- Full-text product search: a generated tsvector over title and SKU, plus
+-- trigram indexes for typo-tolerant fallback matching
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+	GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', coalesce(product_title, '')), 'A') ||
+		setweight(to_tsvector('simple', coalesce(sku, '')), 'A')
+	) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_products_title_trgm ON products USING GIN (product_title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_products_sku_trgm ON products USING GIN (sku gin_trgm_ops);
+
+-- Product variants: a product's options (e.g. Size: S, M, L) generate one
+-- variant per combination of values, each with its own SKU, price override
+-- and stock. The product's quantity and reserved are the sums over its
+-- variants.
+CREATE TABLE product_options (
+	option_id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	option_name VARCHAR(100) NOT NULL,
+	option_values TEXT[] NOT NULL,
+	position INT NOT NULL,
+	UNIQUE (product_id, option_name),
+	CONSTRAINT fk_option_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE CASCADE
+);
+
+CREATE TABLE product_variants (
+	variant_id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	sku VARCHAR(255) NOT NULL,
+	options JSONB NOT NULL,
+	price_override DECIMAL(10, 2),
+	quantity INT NOT NULL DEFAULT 0,
+	reserved INT NOT NULL DEFAULT 0 CHECK (reserved >= 0),
+	image_url VARCHAR(255),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (product_id, options),
+	CONSTRAINT fk_variant_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_product_variants_sku ON product_variants (lower(sku));
+
+-- Stock reservations: quantity held for a cart or order until it is confirmed,
+-- released or expires. products.reserved is the sum of all held quantities.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS reserved INT NOT NULL DEFAULT 0 CHECK (reserved >= 0);
+
+CREATE TABLE stock_reservations (
+	reservation_id SERIAL PRIMARY KEY,
+	reference VARCHAR(255) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'held',
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_stock_reservations_held ON stock_reservations (expires_at) WHERE status = 'held';
+
+CREATE TABLE stock_reservation_items (
+	item_id SERIAL PRIMARY KEY,
+	reservation_id INT NOT NULL,
+	product_id INT NOT NULL,
+	variant_id INT,
+	quantity INT NOT NULL CHECK (quantity > 0),
+	CONSTRAINT fk_reservation FOREIGN KEY (reservation_id) REFERENCES stock_reservations (reservation_id) ON DELETE CASCADE,
+	CONSTRAINT fk_reservation_product FOREIGN KEY (product_id) REFERENCES products (product_id)
+);
+
+CREATE UNIQUE INDEX idx_stock_reservation_items_unique ON stock_reservation_items (reservation_id, product_id, COALESCE(variant_id, 0));
+
+-- Low stock alerts: a low_stock event is published when quantity drops to
+-- low_stock_threshold, and low_stock_alerted stops it repeating until
+-- quantity rises above the threshold again
+ALTER TABLE products ADD COLUMN IF NOT EXISTS low_stock_threshold INT CHECK (low_stock_threshold >= 0);
+ALTER TABLE products ADD COLUMN IF NOT EXISTS low_stock_alerted BOOLEAN NOT NULL DEFAULT false;
+
+-- Inventory ledger: every change to products.quantity is recorded here, and
+-- rows are never updated or deleted. There is deliberately no foreign key on
+-- product_id so that the history outlives the product.
+CREATE TABLE inventory_movements (
+	movement_id BIGSERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	variant_id INT,
+	quantity_change INT NOT NULL CHECK (quantity_change <> 0),
+	quantity_after INT NOT NULL,
+	reason VARCHAR(20) NOT NULL CHECK (reason IN ('restock', 'sale', 'return', 'adjustment', 'reservation')),
+	actor VARCHAR(255) NOT NULL,
+	reference_id VARCHAR(255),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_inventory_movements_product ON inventory_movements (product_id, movement_id);
+
+CREATE FUNCTION forbid_inventory_movement_change() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'inventory_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_inventory_movements_append_only
+	BEFORE UPDATE OR DELETE ON inventory_movements
+	FOR EACH ROW EXECUTE FUNCTION forbid_inventory_movement_change();
+
+-- Opening balance for stock that predates the ledger
+INSERT INTO inventory_movements (product_id, quantity_change, quantity_after, reason, actor, reference_id)
+SELECT product_id, quantity, quantity, 'adjustment', 'system', 'opening-balance'
+FROM products
+WHERE COALESCE(quantity, 0) <> 0;
+
+-- Transactional outbox: events are written here in the same transaction as
+-- the change that raised them, and a relay publishes them to RabbitMQ
+CREATE TABLE outbox (
+	outbox_id BIGSERIAL PRIMARY KEY,
+	aggregate_type VARCHAR(50) NOT NULL,
+	aggregate_id VARCHAR(255) NOT NULL,
+	destination VARCHAR(255) NOT NULL,
+	payload JSONB NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	published_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_outbox_pending ON outbox (next_attempt_at, outbox_id) WHERE published_at IS NULL;
+CREATE INDEX idx_outbox_pending_aggregate ON outbox (aggregate_type, aggregate_id, outbox_id) WHERE published_at IS NULL;
+
+-- Typed product attributes: a category declares attributes that products in
+-- it and its subcategories carry in products.attributes
+CREATE TABLE category_attributes (
+	attribute_id SERIAL PRIMARY KEY,
+	category_id INT NOT NULL,
+	code VARCHAR(50) NOT NULL CHECK (code ~ '^[a-z][a-z0-9_]*$'),
+	label VARCHAR(255) NOT NULL,
+	attribute_type VARCHAR(20) NOT NULL CHECK (attribute_type IN ('number', 'text', 'enum', 'boolean')),
+	unit VARCHAR(20),
+	allowed_values TEXT[],
+	required BOOLEAN NOT NULL DEFAULT false,
+	position INT NOT NULL,
+	UNIQUE (category_id, code),
+	CONSTRAINT fk_attribute_category FOREIGN KEY (category_id) REFERENCES categories (category_id) ON DELETE CASCADE
+);
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_products_attributes ON products USING GIN (attributes);
+
+-- Prices are exact amounts in the product's currency (ISO 4217)
+ALTER TABLE products ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+-- Price lists: named prices in one currency, optionally for one customer
+-- group and a period of time. Products without a price in the selected list
+-- are converted from their own price through exchange_rates.
+CREATE TABLE price_lists (
+	price_list_id SERIAL PRIMARY KEY,
+	code VARCHAR(50) NOT NULL UNIQUE,
+	name VARCHAR(255) NOT NULL,
+	currency CHAR(3) NOT NULL,
+	customer_group VARCHAR(50),
+	valid_from TIMESTAMP,
+	valid_to TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK (valid_from < valid_to)
+);
+
+CREATE TABLE price_list_prices (
+	price_list_id INT NOT NULL,
+	product_id INT NOT NULL,
+	variant_id INT,
+	price DECIMAL(10, 2) NOT NULL CHECK (price >= 0),
+	CONSTRAINT fk_price_list FOREIGN KEY (price_list_id) REFERENCES price_lists (price_list_id) ON DELETE CASCADE,
+	CONSTRAINT fk_price_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE CASCADE,
+	CONSTRAINT fk_price_variant FOREIGN KEY (variant_id) REFERENCES product_variants (variant_id) ON DELETE CASCADE
+);
+
+-- One price per product, and per variant, in each list
+CREATE UNIQUE INDEX idx_price_list_prices_item ON price_list_prices (price_list_id, product_id, COALESCE(variant_id, 0));
+
+CREATE TABLE exchange_rates (
+	base_currency CHAR(3) NOT NULL,
+	quote_currency CHAR(3) NOT NULL,
+	rate NUMERIC(18, 8) NOT NULL CHECK (rate > 0),
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (base_currency, quote_currency)
+);
+
+-- Price history: every price a product has had or is scheduled to have. The
+-- active row with no effective_to is the regular price; an active row with
+-- one, such as a sale, overrides it until then.
+CREATE TABLE product_prices (
+	price_id BIGSERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	price DECIMAL(10, 2) NOT NULL CHECK (price >= 0),
+	currency CHAR(3) NOT NULL,
+	effective_from TIMESTAMP NOT NULL,
+	effective_to TIMESTAMP,
+	scheduled BOOLEAN NOT NULL DEFAULT false,
+	status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'active', 'ended', 'cancelled')),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK (effective_from < effective_to),
+	CONSTRAINT fk_price_history_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_prices_product ON product_prices (product_id, effective_from);
+CREATE INDEX idx_product_prices_due ON product_prices (effective_from) WHERE status = 'pending';
+CREATE INDEX idx_product_prices_ending ON product_prices (effective_to) WHERE status = 'active';
+
+-- Opening history for prices that predate it
+INSERT INTO product_prices (product_id, price, currency, effective_from, status)
+SELECT product_id, price_unit, currency, COALESCE(created_at, CURRENT_TIMESTAMP), 'active'
+FROM products
+WHERE price_unit IS NOT NULL;
+
+-- Bulk product imports and their progress
+CREATE TABLE import_jobs (
+	job_id SERIAL PRIMARY KEY,
+	status VARCHAR(20) NOT NULL CHECK (status IN ('queued', 'running', 'succeeded', 'failed')),
+	format VARCHAR(20) NOT NULL,
+	dry_run BOOLEAN NOT NULL DEFAULT false,
+	rows_read INT NOT NULL DEFAULT 0,
+	created INT NOT NULL DEFAULT 0,
+	updated INT NOT NULL DEFAULT 0,
+	failed INT NOT NULL DEFAULT 0,
+	errors JSONB NOT NULL DEFAULT '[]',
+	message TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	started_at TIMESTAMP,
+	finished_at TIMESTAMP
+);
+
+-- Uploaded product images, in order. Their files are kept in the blob store
+-- under products/<product_id>/<image_id>/. Positions are only checked for
+-- uniqueness at commit, so images can be reordered in one statement.
+CREATE TABLE product_images (
+	image_id SERIAL PRIMARY KEY,
+	product_id INT NOT NULL,
+	position INT NOT NULL CHECK (position >= 0),
+	content_type VARCHAR(50) NOT NULL,
+	width INT NOT NULL,
+	height INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT uq_product_image_position UNIQUE (product_id, position) DEFERRABLE INITIALLY DEFERRED,
+	CONSTRAINT fk_image_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE CASCADE
+);
+
+-- Blob store folder of a category's uploaded image
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS image_key VARCHAR(255);
+
+-- Deleted products and categories are archived first, and only purged once
+-- they have been archived for the retention period
+ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_products_archived ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_categories_archived ON categories (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Every change to a product or category moves its version on. The API
+-- serves versions as entity tags for conditional requests.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+CREATE FUNCTION bump_version() RETURNS trigger AS $$
+BEGIN
+	NEW.version := OLD.version + 1;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_products_version
+	BEFORE UPDATE ON products
+	FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_categories_version
+	BEFORE UPDATE ON categories
+	FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+-- A product's options, variants and images are part of it, so changing them
+-- moves the product's version on too
+CREATE FUNCTION bump_product_version() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		UPDATE products SET version = version + 1 WHERE product_id = OLD.product_id;
+	ELSE
+		UPDATE products SET version = version + 1 WHERE product_id = NEW.product_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_product_options_version
+	AFTER INSERT OR UPDATE OR DELETE ON product_options
+	FOR EACH ROW EXECUTE FUNCTION bump_product_version();
+
+CREATE TRIGGER trg_product_variants_version
+	AFTER INSERT OR UPDATE OR DELETE ON product_variants
+	FOR EACH ROW EXECUTE FUNCTION bump_product_version();
+
+CREATE TRIGGER trg_product_images_version
+	AFTER INSERT OR UPDATE OR DELETE ON product_images
+	FOR EACH ROW EXECUTE FUNCTION bump_product_version();
+
+-- SKUs are unique among live products, ignoring case. An archived product's
+-- SKU can be taken by a new product, and restoring it then conflicts.
+CREATE UNIQUE INDEX IF NOT EXISTS uq_products_sku ON products (lower(sku)) WHERE deleted_at IS NULL;
+
+-- Every insert or update of a product or category is stamped with the id of
+-- its transaction, which orders the change feed. Soft deletes are updates,
+-- so archived rows stay in the feed as tombstones until they are purged,
+-- when catalog_tombstones takes over.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS change_txid BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS change_txid BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_products_change ON products (change_txid, product_id);
+CREATE INDEX IF NOT EXISTS idx_categories_change ON categories (change_txid, category_id);
+
+CREATE FUNCTION stamp_change() RETURNS trigger AS $$
+BEGIN
+	NEW.change_txid := pg_current_xact_id()::text::bigint;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_products_change
+	BEFORE INSERT OR UPDATE ON products
+	FOR EACH ROW EXECUTE FUNCTION stamp_change();
+
+CREATE TRIGGER trg_categories_change
+	BEFORE INSERT OR UPDATE ON categories
+	FOR EACH ROW EXECUTE FUNCTION stamp_change();
+
+-- Products move from draft through review to published, and only published
+-- products are shown to the public. Products from before the lifecycle stay
+-- published; new ones start as drafts. A scheduler publishes products in
+-- review at publish_at and unpublishes published ones at unpublish_at.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'published'
+	CHECK (status IN ('draft', 'in_review', 'published', 'unpublished'));
+ALTER TABLE products ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE products ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS unpublish_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_products_publish_at ON products (publish_at) WHERE status = 'in_review';
+CREATE INDEX IF NOT EXISTS idx_products_unpublish_at ON products (unpublish_at) WHERE status = 'published';
+
+-- Stock is kept per warehouse. products.quantity stays the total across
+-- warehouses, and every movement records how it fell on each one. Stock
+-- moved without naming a warehouse is received into the default warehouse,
+-- which holds the stock from before there were warehouses.
+CREATE TABLE warehouses (
+	warehouse_id SERIAL PRIMARY KEY,
+	code VARCHAR(32) NOT NULL UNIQUE,
+	name VARCHAR(255) NOT NULL,
+	region VARCHAR(64),
+	priority INT NOT NULL DEFAULT 100 CHECK (priority >= 0),
+	active BOOLEAN NOT NULL DEFAULT true,
+	is_default BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK (active OR NOT is_default)
+);
+
+CREATE UNIQUE INDEX uq_warehouses_default ON warehouses (is_default) WHERE is_default;
+
+CREATE TABLE warehouse_stock (
+	warehouse_id INT NOT NULL REFERENCES warehouses (warehouse_id),
+	product_id INT NOT NULL REFERENCES products (product_id) ON DELETE CASCADE,
+	quantity INT NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (warehouse_id, product_id)
+);
+
+CREATE INDEX idx_warehouse_stock_product ON warehouse_stock (product_id);
+
+INSERT INTO warehouses (code, name, priority, is_default) VALUES ('MAIN', 'Main warehouse', 100, true);
+
+INSERT INTO warehouse_stock (warehouse_id, product_id, quantity)
+SELECT w.warehouse_id, p.product_id, p.quantity
+FROM products p, warehouses w
+WHERE w.is_default AND p.quantity > 0;
+
+-- A transfer between warehouses is a pair of movements sharing a transfer_id
+ALTER TABLE inventory_movements DROP CONSTRAINT IF EXISTS inventory_movements_reason_check;
+ALTER TABLE inventory_movements ADD CONSTRAINT inventory_movements_reason_check
+	CHECK (reason IN ('restock', 'sale', 'return', 'adjustment', 'reservation', 'transfer'));
+ALTER TABLE inventory_movements ADD COLUMN IF NOT EXISTS transfer_id BIGINT;
+
+CREATE SEQUENCE IF NOT EXISTS inventory_transfer_seq;
+
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_transfer ON inventory_movements (transfer_id) WHERE transfer_id IS NOT NULL;
+
+CREATE TABLE inventory_movement_warehouses (
+	movement_id BIGINT NOT NULL REFERENCES inventory_movements (movement_id),
+	warehouse_id INT NOT NULL REFERENCES warehouses (warehouse_id),
+	quantity_change INT NOT NULL CHECK (quantity_change <> 0),
+	quantity_after INT NOT NULL,
+	PRIMARY KEY (movement_id, warehouse_id)
+);
+
+CREATE TRIGGER trg_inventory_movement_warehouses_append_only
+	BEFORE UPDATE OR DELETE ON inventory_movement_warehouses
+	FOR EACH ROW EXECUTE FUNCTION forbid_inventory_movement_change();
+
+-- Purged products and categories leave a tombstone here, stamped with the
+-- purging transaction, so the change feed still reports them as deleted
+CREATE TABLE catalog_tombstones (
+	kind SMALLINT NOT NULL CHECK (kind IN (1, 2)),
+	id INT NOT NULL,
+	deleted_at TIMESTAMP NOT NULL,
+	change_txid BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (kind, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_catalog_tombstones_change ON catalog_tombstones (change_txid, kind, id);
+
+CREATE TRIGGER trg_catalog_tombstones_change
+	BEFORE INSERT OR UPDATE ON catalog_tombstones
+	FOR EACH ROW EXECUTE FUNCTION stamp_change();
+
+-- A reservation item may be held in the warehouse it ships from, such as one
+-- an allocation picked. warehouse_stock.reserved is the sum held there, which
+-- allocation and movements that name no warehouse leave alone.
+ALTER TABLE warehouse_stock ADD COLUMN IF NOT EXISTS reserved INT NOT NULL DEFAULT 0 CHECK (reserved >= 0);
+ALTER TABLE stock_reservation_items ADD COLUMN IF NOT EXISTS warehouse_id INT REFERENCES warehouses (warehouse_id);
+
+DROP INDEX IF EXISTS idx_stock_reservation_items_unique;
+CREATE UNIQUE INDEX idx_stock_reservation_items_unique ON stock_reservation_items (reservation_id, product_id, COALESCE(variant_id, 0), COALESCE(warehouse_id, 0));
+
+-- Product and variant SKUs share one namespace: a SKU names a live product
+-- or a variant, never both. No index spans both tables, so writers of the
+-- same SKU take a lock on it and check the other table.
+CREATE FUNCTION check_product_sku() RETURNS trigger AS $$
+BEGIN
+	IF NEW.sku IS NULL OR NEW.deleted_at IS NOT NULL THEN
+		RETURN NEW;
+	END IF;
+
+	PERFORM pg_advisory_xact_lock(hashtext('sku:' || lower(NEW.sku)));
+
+	IF EXISTS (SELECT 1 FROM product_variants WHERE lower(sku) = lower(NEW.sku)) THEN
+		RAISE EXCEPTION 'sku % is already used by a variant', NEW.sku
+			USING ERRCODE = 'unique_violation', CONSTRAINT = 'uq_sku_namespace';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION check_variant_sku() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_advisory_xact_lock(hashtext('sku:' || lower(NEW.sku)));
+
+	IF EXISTS (SELECT 1 FROM products WHERE lower(sku) = lower(NEW.sku) AND deleted_at IS NULL) THEN
+		RAISE EXCEPTION 'sku % is already used by a product', NEW.sku
+			USING ERRCODE = 'unique_violation', CONSTRAINT = 'uq_sku_namespace';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_products_sku
+	BEFORE INSERT OR UPDATE OF sku, deleted_at ON products
+	FOR EACH ROW EXECUTE FUNCTION check_product_sku();
+
+CREATE TRIGGER trg_product_variants_sku
+	BEFORE INSERT OR UPDATE OF sku ON product_variants
+	FOR EACH ROW EXECUTE FUNCTION check_variant_sku();
+
 -- Now create the payment_service database and tables
 
 CREATE DATABASE payment_service;
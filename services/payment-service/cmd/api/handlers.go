@@ -53,6 +53,12 @@ func (app *Config) CreatePayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = payment.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	newPayment, err := app.Models.Payment.Insert(payment)
 	if err != nil {
 		app.errorJSON(w, err)
@@ -70,6 +76,12 @@ func (app *Config) UpdatePayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = payment.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	updatedPayment, err := app.Models.Payment.Update(payment)
 	if err != nil {
 		app.errorJSON(w, err)
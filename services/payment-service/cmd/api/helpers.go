@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
+	"log"
 	"net/http"
+
+	"payment/data"
+
+	"github.com/jackc/pgconn"
 )
 
-type jsonResponse struct {
-	Error   bool        `json:"error"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+// problem is an RFC 7807 problem details response.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors maps each request field at fault to what is wrong with it.
+	Errors map[string]string `json:"errors,omitempty"`
 }
 
 func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
@@ -37,13 +47,14 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data interface{}
 		return err
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+
 	if len(headers) > 0 {
 		for key, value := range headers[0] {
 			w.Header()[key] = value
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 	if err != nil {
@@ -53,16 +64,55 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data interface{}
 	return nil
 }
 
+// errorJSON writes err as a problem details response. The status is picked
+// by errorStatus unless one is given. The details of server errors are
+// logged rather than sent.
 func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
+	err = data.Classify(err)
 
+	statusCode := errorStatus(err)
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
-	var payload jsonResponse
-	payload.Error = true
-	payload.Message = err.Error()
+	payload := problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: err.Error(),
+	}
+
+	var verr *data.ValidationError
+	if errors.As(err, &verr) {
+		payload.Errors = verr.Fields
+	}
 
-	return app.writeJSON(w, statusCode, payload)
+	if statusCode >= http.StatusInternalServerError {
+		log.Println("Error:", err)
+		payload.Detail = ""
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/problem+json")
+
+	return app.writeJSON(w, statusCode, payload, headers)
+}
+
+// errorStatus picks the HTTP status for an error. Errors from the data layer
+// are expected to have been through data.Classify.
+func errorStatus(err error) int {
+	var pgErr *pgconn.PgError
+
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, data.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, data.ErrInvalid):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &pgErr), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
 }
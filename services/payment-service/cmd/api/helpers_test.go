@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+
+	"payment/data"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no rows", sql.ErrNoRows, http.StatusNotFound},
+		{"unique", &pgconn.PgError{Code: "23505"}, http.StatusConflict},
+		{"foreign key", &pgconn.PgError{Code: "23503", Message: "insert or update on table"}, http.StatusConflict},
+		{"not null", &pgconn.PgError{Code: "23502"}, http.StatusUnprocessableEntity},
+		{"validation", (&data.ValidationError{Fields: map[string]string{"orderId": "is required"}}).Err(), http.StatusUnprocessableEntity},
+		{"other database error", &pgconn.PgError{Code: "42601"}, http.StatusInternalServerError},
+		{"bad request", errors.New("invalid payment id"), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStatus(data.Classify(tt.err)); got != tt.want {
+				t.Errorf("errorStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
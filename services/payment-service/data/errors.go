@@ -0,0 +1,109 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgconn"
+)
+
+var (
+	// ErrNotFound is returned when a row a caller asked for does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is returned when a change clashes with the rows already
+	// stored, such as a duplicate key or a reference to a missing row.
+	ErrConflict = errors.New("conflict")
+
+	// ErrInvalid is returned when an input breaks a rule on its values.
+	// Errors listing the fields at fault are a *ValidationError.
+	ErrInvalid = errors.New("invalid input")
+)
+
+// ValidationError lists the fields of an input that are not valid.
+type ValidationError struct {
+	// Fields maps each field at fault to what is wrong with it.
+	Fields map[string]string
+}
+
+// Add records what is wrong with field. Only the first problem found with a
+// field is kept.
+func (e *ValidationError) Add(field, message string) {
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	if _, ok := e.Fields[field]; !ok {
+		e.Fields[field] = message
+	}
+}
+
+// Err returns e if any field is at fault, and nil otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	var problems []string
+	for _, field := range slices.Sorted(maps.Keys(e.Fields)) {
+		problems = append(problems, field+" "+e.Fields[field])
+	}
+	return "invalid input: " + strings.Join(problems, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalid
+}
+
+// dbError is a database error translated by Classify. Its message is safe to
+// show to a client; the error it came from is kept for logging.
+type dbError struct {
+	kind    error
+	message string
+	err     error
+}
+
+func (e *dbError) Error() string {
+	return e.message
+}
+
+func (e *dbError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// Classify translates an error returned by a model method into this
+// package's error kinds: a missing row is ErrNotFound, a unique or foreign
+// key violation ErrConflict, and any other rule the database enforces on
+// values ErrInvalid. Other errors are returned as they are. Model methods
+// return database errors untranslated, so that callers can still match
+// sql.ErrNoRows; Classify is applied where an error is reported.
+func Classify(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return &dbError{kind: ErrNotFound, message: "no such record", err: err}
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch {
+	case pgErr.Code == "23505":
+		return &dbError{kind: ErrConflict, message: "a record with the same key already exists", err: err}
+	case pgErr.Code == "23503" && strings.HasPrefix(pgErr.Message, "update or delete"):
+		return &dbError{kind: ErrConflict, message: "the record is still referred to by others", err: err}
+	case pgErr.Code == "23503":
+		return &dbError{kind: ErrConflict, message: "the record refers to one that does not exist", err: err}
+	case strings.HasPrefix(pgErr.Code, "23"), strings.HasPrefix(pgErr.Code, "22"):
+		// Other integrity violations and data exceptions, such as a check
+		// constraint or a value too long for its column
+		return &dbError{kind: ErrInvalid, message: "a value is not valid for its field", err: err}
+	default:
+		return err
+	}
+}
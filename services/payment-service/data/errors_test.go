@@ -0,0 +1,81 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestClassify(t *testing.T) {
+	other := errors.New("connection reset")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"no rows", fmt.Errorf("payment 1: %w", sql.ErrNoRows), ErrNotFound},
+		{"value too long", &pgconn.PgError{Code: "22001"}, ErrInvalid},
+		{"not null", &pgconn.PgError{Code: "23502"}, ErrInvalid},
+		{"check", &pgconn.PgError{Code: "23514"}, ErrInvalid},
+		{"unique", &pgconn.PgError{Code: "23505"}, ErrConflict},
+		{"missing reference", &pgconn.PgError{Code: "23503", Message: "insert or update on table"}, ErrConflict},
+		{"still referred to", &pgconn.PgError{Code: "23503", Message: "update or delete on table"}, ErrConflict},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, nil},
+		{"other", other, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("Classify() = %v, want it unchanged", got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+			if !errors.Is(got, tt.err) && !errors.Is(got, sql.ErrNoRows) {
+				t.Errorf("Classify() = %v, lost the error it came from", got)
+			}
+		})
+	}
+}
+
+func TestPaymentValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		payment    Payment
+		wantFields []string
+	}{
+		{"valid", Payment{OrderID: 1, PaymentStatus: "paid"}, nil},
+		{"no order", Payment{PaymentStatus: "paid"}, []string{"orderId"}},
+		{"blank status", Payment{OrderID: 1, PaymentStatus: " "}, []string{"paymentStatus"}},
+		{"both", Payment{}, []string{"orderId", "paymentStatus"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.payment.Validate()
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var v *ValidationError
+			if !errors.As(err, &v) || !errors.Is(err, ErrInvalid) {
+				t.Fatalf("Validate() error = %v, want a *ValidationError", err)
+			}
+			if len(v.Fields) != len(tt.wantFields) {
+				t.Errorf("fields = %v, want %v", v.Fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 )
 
@@ -27,6 +28,21 @@ type Payment struct {
 	UpdatedAt     time.Time `json:"-"`
 }
 
+// Validate checks the fields of a payment that a client sets. It returns a
+// *ValidationError listing every field at fault.
+func (p Payment) Validate() error {
+	var v ValidationError
+
+	if p.OrderID <= 0 {
+		v.Add("orderId", "must be a positive order id")
+	}
+	if strings.TrimSpace(p.PaymentStatus) == "" {
+		v.Add("paymentStatus", "must not be empty")
+	}
+
+	return v.Err()
+}
+
 type PaymentModel struct {
 	DB *sql.DB
 }
@@ -130,7 +146,7 @@ func (m *PaymentModel) Update(payment Payment) (*Payment, error) {
 		WHERE payment_id = $5
 	`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	res, err := m.DB.ExecContext(ctx, query,
 		payment.OrderID,
 		payment.IsPayed,
 		payment.PaymentStatus,
@@ -142,6 +158,11 @@ func (m *PaymentModel) Update(payment Payment) (*Payment, error) {
 		return nil, err
 	}
 
+	err = checkAffected(res)
+	if err != nil {
+		return nil, err
+	}
+
 	return &payment, nil
 }
 
@@ -151,6 +172,22 @@ func (m *PaymentModel) Delete(id int) error {
 
 	query := `DELETE FROM payments WHERE payment_id = $1`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
-	return err
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// checkAffected returns sql.ErrNoRows if a statement changed no rows.
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// keySetMaxAge is how long keys fetched from a URL are used before they
+	// are fetched again, so that a revoked key stops being accepted.
+	keySetMaxAge = time.Hour
+
+	// keySetMinRefresh limits how often a token signed with an unknown key
+	// can make the key set be fetched again.
+	keySetMinRefresh = time.Minute
+
+	// keySetTimeout bounds fetching keys from a URL.
+	keySetTimeout = 10 * time.Second
+)
+
+// KeySet holds the RSA public keys of a JSON Web Key Set, read from a file
+// or fetched from a URL. Keys from a URL are fetched again once they grow
+// old, or when a token names a key the set does not have, so that the
+// issuer can rotate its keys. A slow fetch only holds up the tokens that
+// need the keys it brings.
+type KeySet struct {
+	source string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	// attempted is when the keys were last fetched, or tried to be.
+	attempted time.Time
+	// refreshing is closed when the fetch in flight, if any, is done.
+	refreshing chan struct{}
+}
+
+// LoadKeySet reads the key set at source, an http(s) URL or a file path.
+func LoadKeySet(source string) (*KeySet, error) {
+	s := &KeySet{
+		source: source,
+		client: &http.Client{Timeout: keySetTimeout},
+	}
+
+	keys, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	s.keys = keys
+	s.fetched = time.Now()
+	s.attempted = s.fetched
+
+	return s, nil
+}
+
+// Key returns the key with the given id. A token with no key id may use the
+// only key of a set that has one.
+func (s *KeySet) Key(kid string) (*rsa.PublicKey, error) {
+	if s.remote() {
+		s.refresh(kid)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("auth: unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// lookup finds the key for kid among those held. The caller holds s.mu.
+func (s *KeySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// refresh fetches the keys again if they have grown old, or if kid is not
+// among them, at most once every keySetMinRefresh. Only one fetch runs at a
+// time, and it runs without holding s.mu: callers that need a key it may
+// bring wait for it, while the rest go on with the keys already held.
+func (s *KeySet) refresh(kid string) {
+	s.mu.Lock()
+
+	_, known := s.lookup(kid)
+	stale := time.Since(s.fetched) > keySetMaxAge
+	due := time.Since(s.attempted) > keySetMinRefresh
+
+	inFlight := s.refreshing
+	if inFlight != nil || !due || (known && !stale) {
+		s.mu.Unlock()
+		if inFlight != nil && !known {
+			<-inFlight
+		}
+		return
+	}
+
+	done := make(chan struct{})
+	s.refreshing = done
+	s.attempted = time.Now()
+	s.mu.Unlock()
+
+	keys, err := s.read()
+
+	s.mu.Lock()
+	// Keep using the keys already fetched if the issuer is unreachable
+	if err != nil {
+		log.Println("Error refreshing JWKS:", err)
+	} else {
+		s.keys = keys
+		s.fetched = time.Now()
+	}
+	s.refreshing = nil
+	s.mu.Unlock()
+
+	close(done)
+}
+
+func (s *KeySet) remote() bool {
+	return strings.HasPrefix(s.source, "http://") || strings.HasPrefix(s.source, "https://")
+}
+
+// read reads the key set from its source.
+func (s *KeySet) read() (map[string]*rsa.PublicKey, error) {
+	var body []byte
+	var err error
+
+	if s.remote() {
+		body, err = s.fetch()
+	} else {
+		body, err = os.ReadFile(s.source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("auth: reading JWKS: %w", err)
+	}
+
+	return parseKeySet(body)
+}
+
+func (s *KeySet) fetch() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keySetTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", s.source, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// jwk is a JSON Web Key. Only the fields of RSA signing keys are read.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// parseKeySet reads the RSA signing keys of a key set, skipping keys of
+// any other kind.
+func parseKeySet(body []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err := json.Unmarshal(body, &set)
+	if err != nil {
+		return nil, fmt.Errorf("auth: parsing JWKS: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("auth: key %q: bad modulus: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("auth: key %q: bad exponent: %w", k.Kid, err)
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("auth: key %q: bad exponent", k.Kid)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("auth: JWKS has no RSA signing keys")
+	}
+
+	return keys, nil
+}
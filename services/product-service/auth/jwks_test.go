@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jwksServer serves the keys in kids as a JSON Web Key Set and counts the
+// requests it gets. Requests wait for unblock, if it is set.
+type jwksServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	kids     []string
+	requests int
+	started  chan struct{}
+	unblock  chan struct{}
+}
+
+func newJWKSServer(t *testing.T, kids ...string) *jwksServer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &jwksServer{kids: kids}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		kids, started, unblock := s.kids, s.started, s.unblock
+		s.mu.Unlock()
+
+		if started != nil {
+			started <- struct{}{}
+		}
+		if unblock != nil {
+			<-unblock
+		}
+
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		for _, kid := range kids {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestKeySetRefreshesUnknownKeysAtMostOncePerInterval(t *testing.T) {
+	server := newJWKSServer(t, "a")
+
+	set, err := LoadKeySet(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The issuer rotates to a new key
+	server.mu.Lock()
+	server.kids = []string{"a", "b"}
+	server.mu.Unlock()
+
+	// Within the minimum interval, an unknown key does not fetch again
+	if _, err := set.Key("b"); err == nil {
+		t.Fatal("Key(b) found a key before the set was fetched again")
+	}
+	if n := server.count(); n != 1 {
+		t.Fatalf("fetched %d times, want 1", n)
+	}
+
+	set.mu.Lock()
+	set.attempted = time.Now().Add(-2 * keySetMinRefresh)
+	set.mu.Unlock()
+
+	if _, err := set.Key("b"); err != nil {
+		t.Fatalf("Key(b) error = %v after the interval", err)
+	}
+	if _, err := set.Key("c"); err == nil {
+		t.Fatal("Key(c) found a key the issuer does not have")
+	}
+	if n := server.count(); n != 2 {
+		t.Fatalf("fetched %d times, want 2", n)
+	}
+}
+
+func TestKeySetRefreshDoesNotHoldUpKnownKeys(t *testing.T) {
+	server := newJWKSServer(t, "a")
+
+	set, err := LoadKeySet(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	server.started = make(chan struct{})
+	server.unblock = make(chan struct{})
+	server.mu.Unlock()
+
+	// The keys have grown old, so the next use fetches them again, and the
+	// issuer is slow to answer
+	set.mu.Lock()
+	set.fetched = time.Now().Add(-2 * keySetMaxAge)
+	set.attempted = set.fetched
+	set.mu.Unlock()
+
+	refreshed := make(chan error)
+	go func() {
+		_, err := set.Key("a")
+		refreshed <- err
+	}()
+	<-server.started
+
+	// Meanwhile the key already held is still served
+	got := make(chan error)
+	go func() {
+		_, err := set.Key("a")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("Key(a) error = %v during a refresh", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Key(a) waited for the refresh in flight")
+	}
+
+	close(server.unblock)
+	if err := <-refreshed; err != nil {
+		t.Fatalf("Key(a) error = %v after the refresh", err)
+	}
+	if n := server.count(); n != 2 {
+		t.Fatalf("fetched %d times, want 2", n)
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// leeway allows for clock skew between the issuer and this service when
+// checking a token's times.
+const leeway = 30 * time.Second
+
+// tokenTypeAccess is the token_type of the access tokens user-service
+// issues. Its refresh tokens are signed with the same key and must not be
+// accepted in their place.
+const tokenTypeAccess = "access"
+
+// ErrTokenType is returned for a token that is not an access token.
+var ErrTokenType = errors.New("auth: not an access token")
+
+// Claims are the claims of a verified token.
+type Claims struct {
+	jwt.RegisteredClaims
+	// UserID is the user the token was issued to. user-service sends it
+	// in user_id, as a number, instead of sub.
+	UserID json.Number `json:"user_id,omitempty"`
+	// TokenType is access or refresh.
+	TokenType string `json:"token_type,omitempty"`
+	// Roles and Scope both grant roles, as a list or as a space separated
+	// string, whichever the issuer uses.
+	Roles []string `json:"roles,omitempty"`
+	Scope string   `json:"scope,omitempty"`
+	// CustomerGroup is the customer group of the user, if any, which
+	// selects the price lists limited to it.
+	CustomerGroup string `json:"customer_group,omitempty"`
+}
+
+// Actor names the user the token was issued to: its subject, or else its
+// user_id.
+func (c *Claims) Actor() string {
+	if c.Subject != "" {
+		return c.Subject
+	}
+	return c.UserID.String()
+}
+
+// HasRole reports whether the token grants role.
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role) || slices.Contains(strings.Fields(c.Scope), role)
+}
+
+// Config selects how tokens are verified. Exactly one of Secret and Keys
+// must be set, and Issuer and Audience are required.
+type Config struct {
+	// Secret verifies HS256 tokens signed with a shared secret.
+	Secret []byte
+	// Keys verifies RS256 tokens signed with one of its keys.
+	Keys *KeySet
+	// Issuer and Audience must match every token's iss and aud.
+	Issuer   string
+	Audience string
+}
+
+// Verifier checks the signature and claims of bearer tokens.
+type Verifier struct {
+	parser  *jwt.Parser
+	keyfunc jwt.Keyfunc
+}
+
+// NewVerifier returns a Verifier for cfg.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	var method string
+	var keyfunc jwt.Keyfunc
+
+	switch {
+	case cfg.Secret != nil && cfg.Keys != nil:
+		return nil, errors.New("auth: configure a secret or a key set, not both")
+	case cfg.Secret != nil:
+		method = jwt.SigningMethodHS256.Alg()
+		keyfunc = func(*jwt.Token) (any, error) {
+			return cfg.Secret, nil
+		}
+	case cfg.Keys != nil:
+		method = jwt.SigningMethodRS256.Alg()
+		keyfunc = func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			return cfg.Keys.Key(kid)
+		}
+	default:
+		return nil, errors.New("auth: no secret or key set configured")
+	}
+
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("auth: issuer and audience must be configured")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{method}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+	}
+
+	return &Verifier{parser: jwt.NewParser(opts...), keyfunc: keyfunc}, nil
+}
+
+// Verify checks token and returns its claims. Only access tokens are
+// accepted.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	var claims Claims
+	_, err := v.parser.ParseWithClaims(token, &claims, v.keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenType != tokenTypeAccess {
+		return nil, fmt.Errorf("%w: token_type is %q", ErrTokenType, claims.TokenType)
+	}
+	return &claims, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying claims.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims carried by ctx, if any.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
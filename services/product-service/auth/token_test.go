@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testSecret = []byte("test-secret")
+
+func sign(t *testing.T, claims jwt.MapClaims, secret []byte) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestNewVerifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"secret", Config{Secret: testSecret, Issuer: "user-service", Audience: "ecommerce"}, false},
+		{"no key", Config{Issuer: "user-service", Audience: "ecommerce"}, true},
+		{"secret and keys", Config{Secret: testSecret, Keys: &KeySet{}, Issuer: "user-service", Audience: "ecommerce"}, true},
+		{"no issuer", Config{Secret: testSecret, Audience: "ecommerce"}, true},
+		{"no audience", Config{Secret: testSecret, Issuer: "user-service"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVerifier(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewVerifier() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	v, err := NewVerifier(Config{Secret: testSecret, Issuer: "user-service", Audience: "ecommerce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            "user-service",
+			"aud":            "ecommerce",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"token_type":     "access",
+			"user_id":        42,
+			"roles":          []string{"catalog:write"},
+			"customer_group": "wholesale",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(jwt.MapClaims)
+		secret  []byte
+		wantErr error
+	}{
+		{"valid", func(jwt.MapClaims) {}, testSecret, nil},
+		{"refresh token", func(c jwt.MapClaims) { c["token_type"] = "refresh" }, testSecret, ErrTokenType},
+		{"no token type", func(c jwt.MapClaims) { delete(c, "token_type") }, testSecret, ErrTokenType},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "elsewhere" }, testSecret, jwt.ErrTokenInvalidIssuer},
+		{"no issuer", func(c jwt.MapClaims) { delete(c, "iss") }, testSecret, jwt.ErrTokenRequiredClaimMissing},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "elsewhere" }, testSecret, jwt.ErrTokenInvalidAudience},
+		{"no audience", func(c jwt.MapClaims) { delete(c, "aud") }, testSecret, jwt.ErrTokenRequiredClaimMissing},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, testSecret, jwt.ErrTokenExpired},
+		{"within leeway", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-10 * time.Second).Unix() }, testSecret, nil},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, testSecret, jwt.ErrTokenRequiredClaimMissing},
+		{"wrong secret", func(jwt.MapClaims) {}, []byte("other"), jwt.ErrTokenSignatureInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.edit(claims)
+
+			got, err := v.Verify(sign(t, claims, tt.secret))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if got.Actor() != "42" {
+				t.Errorf("Actor() = %q, want 42", got.Actor())
+			}
+			if !got.HasRole("catalog:write") {
+				t.Error("HasRole(catalog:write) = false, want true")
+			}
+			if got.CustomerGroup != "wholesale" {
+				t.Errorf("CustomerGroup = %q, want wholesale", got.CustomerGroup)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsOtherMethods(t *testing.T) {
+	v, err := NewVerifier(Config{Secret: testSecret, Issuer: "user-service", Audience: "ecommerce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"iss":        "user-service",
+		"aud":        "ecommerce",
+		"exp":        time.Now().Add(time.Hour).Unix(),
+		"token_type": "access",
+	}).SignedString(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = v.Verify(token)
+	if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Errorf("Verify() error = %v, want %v", err, jwt.ErrTokenSignatureInvalid)
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims Claims
+		role   string
+		want   bool
+	}{
+		{"in roles", Claims{Roles: []string{"catalog:write"}}, "catalog:write", true},
+		{"in scope", Claims{Scope: "openid inventory:write"}, "inventory:write", true},
+		{"missing", Claims{Roles: []string{"catalog:write"}, Scope: "openid"}, "inventory:write", false},
+		{"scope prefix", Claims{Scope: "catalog:writer"}, "catalog:write", false},
+		{"none", Claims{}, "catalog:write", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.HasRole(tt.role); got != tt.want {
+				t.Errorf("HasRole(%q) = %v, want %v", tt.role, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActor(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims Claims
+		want   string
+	}{
+		{"subject", Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}, UserID: "7"}, "alice"},
+		{"user id", Claims{UserID: "7"}, "7"},
+		{"none", Claims{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.Actor(); got != tt.want {
+				t.Errorf("Actor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
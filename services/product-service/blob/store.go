@@ -0,0 +1,34 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrNotFound is returned when a blob does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs, such as uploaded images, under slash separated keys
+// like "products/12/34/large.jpg".
+type Store interface {
+	// Put saves the contents of r under key, replacing any blob already
+	// there.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the blob saved under key, or ErrNotFound.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// DeletePrefix removes every blob under prefix, which ends with a slash,
+	// such as "products/12/". A prefix matching nothing is not an error.
+	DeletePrefix(ctx context.Context, prefix string) error
+	// URL returns the address clients fetch the blob under key from.
+	URL(key string) string
+}
+
+// ValidKey reports whether key is a clean relative path that cannot refer
+// outside the store.
+func ValidKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, "/") && path.Clean(key) == key &&
+		key != ".." && !strings.HasPrefix(key, "../")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// purgeArchived periodically removes products and categories that have been
+// archived for longer than retention, along with their images. It runs for
+// the life of the process.
+func (app *Config) purgeArchived(interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		before := time.Now().Add(-retention)
+
+		products, err := app.Models.Product.Purge(before)
+		if err != nil {
+			log.Println("Error purging archived products:", err)
+			continue
+		}
+		for _, id := range products {
+			err = app.Models.Image.DeleteProductFiles(id)
+			if err != nil {
+				log.Printf("Removing images of purged product %d: %v\n", id, err)
+			}
+		}
+
+		// Categories go after products, so a category whose archived
+		// products were just purged can go in the same run
+		categories, err := app.Models.Category.Purge(before)
+		if err != nil {
+			log.Println("Error purging archived categories:", err)
+			continue
+		}
+		err = app.Models.Image.DeleteCategoryFiles(categories...)
+		if err != nil {
+			log.Println("Removing images of purged categories:", err)
+		}
+
+		if len(products) > 0 || len(categories) > 0 {
+			log.Printf("Purged %d archived products and %d archived categories\n", len(products), len(categories))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"product/data"
+)
+
+const (
+	// defaultChangePageSize is how many changes a read of the feed returns
+	// unless size is given.
+	defaultChangePageSize = 100
+	// maxChangeWait is the longest a read of the feed may wait for changes.
+	maxChangeWait = 30 * time.Second
+	// changePollInterval is how often a waiting read checks for changes.
+	changePollInterval = time.Second
+)
+
+// GetChanges lists the products and categories changed since a cursor, for
+// consumers keeping a copy of the catalog in sync. With wait, a read that
+// finds nothing new holds the request open until a change arrives or the
+// wait runs out.
+func (app *Config) GetChanges(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	err := checkQueryParams(qs, "since", "size", "wait")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	cursor, err := data.ParseChangeCursor(qs.Get("since"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	size, err := readIntParam(qs, "size", defaultChangePageSize)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if size < 1 || size > data.MaxChangePageSize {
+		app.errorJSON(w, fmt.Errorf("size must be between 1 and %d", data.MaxChangePageSize))
+		return
+	}
+
+	waitSeconds, err := readIntParam(qs, "wait", 0)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	wait := time.Duration(waitSeconds) * time.Second
+	if wait < 0 || wait > maxChangeWait {
+		app.errorJSON(w, fmt.Errorf("wait must be between 0 and %d seconds", int(maxChangeWait.Seconds())))
+		return
+	}
+
+	deadline := time.Now().Add(wait)
+
+	var page *data.ChangePage
+	for {
+		page, err = app.Models.Change.Since(cursor, size)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		if len(page.Changes) > 0 || time.Until(deadline) <= 0 {
+			break
+		}
+
+		// The cursor may have moved on past transactions with nothing to
+		// show, so the next check starts from there
+		cursor, err = data.ParseChangeCursor(page.Cursor)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(min(changePollInterval, time.Until(deadline))):
+		}
+	}
+
+	var products []*data.Product
+	for _, c := range page.Changes {
+		if c.Product != nil {
+			products = append(products, c.Product)
+		}
+	}
+
+	err = app.Models.Image.Load(products...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"product/data"
+)
+
+// errPreconditionFailed is returned when an If-Match header names none of
+// the resource's current entity tags.
+var errPreconditionFailed = errors.New("precondition failed: modified since it was read")
+
+// productETag is the entity tag of a product. The product's representation
+// includes its category, so the category's version is part of it.
+func productETag(p *data.Product) string {
+	categoryVersion := 0
+	if p.Category != nil {
+		categoryVersion = p.Category.Version
+	}
+	return etag(p.Version, categoryVersion)
+}
+
+// categoryETag is the entity tag of a category. Its representation includes
+// its parents up to the root, so their versions are part of it.
+func categoryETag(c *data.Category) string {
+	var versions []int
+	for ; c != nil; c = c.ParentCategory {
+		versions = append(versions, c.Version)
+	}
+	return etag(versions...)
+}
+
+// etag formats versions as a strong entity tag.
+func etag(versions ...int) string {
+	parts := make([]string, len(versions))
+	for i, v := range versions {
+		parts[i] = strconv.Itoa(v)
+	}
+	return `"` + strings.Join(parts, ".") + `"`
+}
+
+// etagListContains reports whether the entity tags listed in an If-Match or
+// If-None-Match header include tag. A weak comparison also accepts a weak
+// tag with the same value.
+func etagListContains(values []string, tag string, weak bool) bool {
+	for _, value := range values {
+		for _, t := range strings.Split(value, ",") {
+			t = strings.TrimSpace(t)
+			if weak {
+				t = strings.TrimPrefix(t, "W/")
+			}
+			if t == "*" || t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns errPreconditionFailed if r has an If-Match header that
+// does not name tag.
+func checkIfMatch(r *http.Request, tag string) error {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 || etagListContains(values, tag, false) {
+		return nil
+	}
+	return errPreconditionFailed
+}
+
+// notModified answers r with 304 Not Modified if its If-None-Match header
+// names tag, and reports whether it did.
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	values := r.Header.Values("If-None-Match")
+	if len(values) == 0 || !etagListContains(values, tag, true) {
+		return false
+	}
+
+	w.Header().Set("ETag", tag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
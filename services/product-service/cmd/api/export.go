@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"product/data"
+)
+
+// exportContentTypes are the content types of the export formats.
+var exportContentTypes = map[string]string{
+	data.ExportFormatCSV:    "text/csv; charset=utf-8",
+	data.ExportFormatNDJSON: "application/x-ndjson",
+	data.ExportFormatGMC:    "application/xml; charset=utf-8",
+}
+
+var exportFileNames = map[string]string{
+	data.ExportFormatCSV:    "products.csv",
+	data.ExportFormatNDJSON: "products.ndjson",
+	data.ExportFormatGMC:    "products.xml",
+}
+
+// responseTracker records whether anything has been written to a response,
+// after which an error can no longer be reported with a status code.
+type responseTracker struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (t *responseTracker) Write(b []byte) (int, error) {
+	t.wrote = true
+	return t.ResponseWriter.Write(b)
+}
+
+// ExportProducts streams every product matching the listing filters in the
+// requested format.
+func (app *Config) ExportProducts(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	err := checkQueryParams(qs, "format", "sort", "categoryId", "includeDescendants", "minPrice", "maxPrice", "currency", "inStock", "attr.*", "preview")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	opts := data.ExportOptions{
+		Format:        qs.Get("format"),
+		StorefrontURL: app.StorefrontURL,
+	}
+	if opts.Format == "" {
+		app.errorJSON(w, errors.New("missing export format, one of csv, ndjson or gmc-xml"))
+		return
+	}
+
+	opts.Filter, err = app.readProductFilter(qs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	opts.Filter.IncludeUnpublished, err = app.readPreview(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	opts.Sort, err = data.ParseProductSort(qs.Get("sort"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = opts.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[opts.Format])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFileNames[opts.Format]+`"`)
+
+	out := &responseTracker{ResponseWriter: w}
+
+	_, err = app.Models.Product.Export(r.Context(), out, opts)
+	if err != nil {
+		if !out.wrote {
+			w.Header().Del("Content-Disposition")
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Too late for an error status; abort the response so the client
+		// sees a broken transfer rather than a feed that is silently short
+		log.Printf("Export failed: %v\n", err)
+		panic(http.ErrAbortHandler)
+	}
+}
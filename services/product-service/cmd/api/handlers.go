@@ -1,9 +1,13 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"product/data"
@@ -13,17 +17,248 @@ type DtoCollectionResponse struct {
 	Collection interface{} `json:"collection"`
 }
 
+type DtoPageResponse struct {
+	Collection    interface{}  `json:"collection"`
+	Page          int          `json:"page"`
+	Size          int          `json:"size"`
+	TotalElements int          `json:"totalElements"`
+	TotalPages    int          `json:"totalPages"`
+	Facets        []data.Facet `json:"facets,omitempty"`
+}
+
 // Product Handlers
 
+var productListParams = []string{"page", "size", "sort", "categoryId", "includeDescendants", "minPrice", "maxPrice", "inStock", "attr.*", "facets", "currency", "priceList", "includeDeleted", "preview"}
+
+// readProductFilter reads the product filters from the query string.
+func (app *Config) readProductFilter(qs url.Values) (data.ProductFilter, error) {
+	var filter data.ProductFilter
+	var err error
+
+	filter.CategoryID, err = readOptionalIntParam(qs, "categoryId")
+	if err != nil {
+		return filter, err
+	}
+
+	includeDescendants, err := readOptionalBoolParam(qs, "includeDescendants")
+	if err != nil {
+		return filter, err
+	}
+	filter.IncludeDescendants = includeDescendants != nil && *includeDescendants
+
+	// Price bounds compare the products' own prices, so they are read in
+	// the currency asked for and only match products priced in it
+	currency := qs.Get("currency")
+	if currency == "" {
+		currency = data.DefaultCurrency
+	}
+	err = data.ValidateCurrency(currency)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.MinPrice, err = readOptionalMoneyParam(qs, "minPrice", currency)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.MaxPrice, err = readOptionalMoneyParam(qs, "maxPrice", currency)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.InStock, err = readOptionalBoolParam(qs, "inStock")
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Attributes, err = readAttributeFilters(qs)
+	if err != nil {
+		return filter, err
+	}
+
+	return filter, filter.Validate()
+}
+
+// readAttributeFilters reads attribute filters of the form attr.color=red,blue
+// or attr.weight.min=1&attr.weight.max=5 from the query string.
+func readAttributeFilters(qs url.Values) ([]data.AttributeFilter, error) {
+	byCode := map[string]*data.AttributeFilter{}
+	var codes []string
+
+	filterFor := func(code string) *data.AttributeFilter {
+		f, ok := byCode[code]
+		if !ok {
+			f = &data.AttributeFilter{Code: code}
+			byCode[code] = f
+			codes = append(codes, code)
+		}
+		return f
+	}
+
+	for key := range qs {
+		name, ok := strings.CutPrefix(key, "attr.")
+		if !ok {
+			continue
+		}
+
+		var err error
+		if code, ok := strings.CutSuffix(name, ".min"); ok {
+			filterFor(code).Min, err = readOptionalFloatParam(qs, key)
+		} else if code, ok := strings.CutSuffix(name, ".max"); ok {
+			filterFor(code).Max, err = readOptionalFloatParam(qs, key)
+		} else {
+			f := filterFor(name)
+			for _, v := range strings.Split(qs.Get(key), ",") {
+				if v != "" {
+					f.Values = append(f.Values, v)
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the generated query the same from one request to the next
+	sort.Strings(codes)
+
+	filters := make([]data.AttributeFilter, 0, len(codes))
+	for _, code := range codes {
+		filters = append(filters, *byCode[code])
+	}
+
+	return filters, nil
+}
+
+// readListOptions reads page, size and sort from the query string.
+func (app *Config) readListOptions(qs url.Values) (data.ListOptions, error) {
+	var opts data.ListOptions
+	var err error
+
+	opts.Page, err = readIntParam(qs, "page", 1)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Size, err = readIntParam(qs, "size", data.DefaultPageSize)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Sort, err = data.ParseProductSort(qs.Get("sort"))
+	if err != nil {
+		return opts, err
+	}
+
+	return opts, opts.Validate()
+}
+
 func (app *Config) GetAllProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := app.Models.Product.GetAll()
+	qs := r.URL.Query()
+
+	err := checkQueryParams(qs, productListParams...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	filter, err := app.readProductFilter(qs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	filter.IncludeDeleted, err = app.readIncludeDeleted(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	filter.IncludeUnpublished, err = app.readPreview(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	opts, err := app.readListOptions(qs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	facets, err := readOptionalBoolParam(qs, "facets")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	products, total, err := app.Models.Product.GetAll(filter, opts)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.applyPrices(r, products...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.Image.Load(products...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoPageResponse{
+		Collection:    products,
+		Page:          opts.Page,
+		Size:          opts.Size,
+		TotalElements: total,
+		TotalPages:    (total + opts.Size - 1) / opts.Size,
+	}
+
+	if facets != nil && *facets {
+		payload.Facets, err = app.Models.Product.Facets(filter)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, payload, paginationHeaders(r, opts.Page, opts.Size, total))
+}
+
+func (app *Config) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	err := checkQueryParams(qs, "q", "page", "size")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	q := qs.Get("q")
+	if q == "" {
+		app.errorJSON(w, errors.New("missing search query q"))
+		return
+	}
+
+	opts, err := app.readListOptions(qs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	results, err := app.Models.Product.Search(q, opts)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
 	payload := DtoCollectionResponse{
-		Collection: products,
+		Collection: results,
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
@@ -37,13 +272,92 @@ func (app *Config) GetProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := app.Models.Product.GetOne(productID)
+	includeDeleted, err := app.readIncludeDeleted(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, product)
+	product, err := app.Models.Product.GetOne(productID, includeDeleted)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeProduct(w, r, product)
+}
+
+// checkProduct checks that a product whose sub-resources are read exists
+// and is live, or archived with includeDeleted, and is published, or
+// previewed.
+func (app *Config) checkProduct(r *http.Request, productID int) error {
+	includeDeleted, err := app.readIncludeDeleted(r)
+	if err != nil {
+		return err
+	}
+
+	product, err := app.Models.Product.GetOne(productID, includeDeleted)
+	if err != nil {
+		return err
+	}
+
+	return app.checkPublished(r, product)
+}
+
+// checkPublished hides a product that is not published, as though it did
+// not exist, unless the request asks to preview it.
+func (app *Config) checkPublished(r *http.Request, product *data.Product) error {
+	if product.Status == data.ProductPublished {
+		return nil
+	}
+
+	preview, err := app.readPreview(r)
+	if err != nil {
+		return err
+	}
+	if !preview {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// writeProduct sends a single product, priced as the query string selects
+// and with its images. It answers If-None-Match with 304 while the product
+// is unchanged.
+func (app *Config) writeProduct(w http.ResponseWriter, r *http.Request, product *data.Product) {
+	qs := r.URL.Query()
+
+	err := app.checkPublished(r, product)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Price lists and exchange rates change without the product, so a
+	// response priced from them carries no entity tag
+	headers := http.Header{}
+	if !qs.Has("currency") && !qs.Has("priceList") {
+		tag := productETag(product)
+		if notModified(w, r, tag) {
+			return
+		}
+		headers.Set("ETag", tag)
+	}
+
+	err = app.applyPrices(r, product)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.Image.Load(product)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, product, headers)
 }
 
 func (app *Config) CreateProduct(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +368,12 @@ func (app *Config) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = product.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	newProduct, err := app.Models.Product.Insert(product)
 	if err != nil {
 		app.errorJSON(w, err)
@@ -71,6 +391,12 @@ func (app *Config) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = product.ValidateUpdate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	updatedProduct, err := app.Models.Product.Update(product)
 	if err != nil {
 		app.errorJSON(w, err)
@@ -95,14 +421,41 @@ func (app *Config) UpdateProductWithID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = product.ValidateUpdate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	product.ID = productID
+
+	if r.Header.Get("If-Match") != "" {
+		current, err := app.Models.Product.GetOne(productID, false)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		err = checkIfMatch(r, productETag(current))
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		// The update fails if the product changes before it is saved
+		product.Version = current.Version
+	}
+
 	updatedProduct, err := app.Models.Product.Update(product)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, updatedProduct)
+	headers := http.Header{}
+	headers.Set("ETag", productETag(updatedProduct))
+
+	app.writeJSON(w, http.StatusOK, updatedProduct, headers)
 }
 
 func (app *Config) DeleteProduct(w http.ResponseWriter, r *http.Request) {
@@ -122,10 +475,39 @@ func (app *Config) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, true)
 }
 
+func (app *Config) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	err = app.Models.Product.Restore(productID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	product, err := app.Models.Product.GetOne(productID, false)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, product)
+}
+
 // Category Handlers
 
 func (app *Config) GetAllCategories(w http.ResponseWriter, r *http.Request) {
-	categories, err := app.Models.Category.GetAll()
+	includeDeleted, err := app.readIncludeDeleted(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	categories, err := app.Models.Category.GetAll(includeDeleted)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -146,13 +528,113 @@ func (app *Config) GetCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	category, err := app.Models.Category.GetOne(categoryID)
+	includeDeleted, err := app.readIncludeDeleted(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, category)
+	category, err := app.Models.Category.GetOne(categoryID, includeDeleted)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tag := categoryETag(category)
+	if notModified(w, r, tag) {
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", tag)
+
+	app.writeJSON(w, http.StatusOK, category, headers)
+}
+
+func (app *Config) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := app.Models.Category.Tree()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: tree,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) GetCategoryBreadcrumbs(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "categoryId")
+	categoryID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid category id"))
+		return
+	}
+
+	path, err := app.Models.Category.Breadcrumbs(categoryID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: path,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) GetCategoryAttributes(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "categoryId")
+	categoryID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid category id"))
+		return
+	}
+
+	attributes, err := app.Models.Category.Attributes(categoryID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: attributes,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// SetCategoryAttributes replaces the attributes a category declares. Those
+// it inherits from its ancestors are unaffected.
+func (app *Config) SetCategoryAttributes(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "categoryId")
+	categoryID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid category id"))
+		return
+	}
+
+	var defs []data.AttributeDefinition
+	err = app.readJSON(w, r, &defs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	attributes, err := app.Models.Category.SetAttributes(categoryID, defs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: attributes,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
 }
 
 func (app *Config) CreateCategory(w http.ResponseWriter, r *http.Request) {
@@ -163,6 +645,12 @@ func (app *Config) CreateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = category.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	newCategory, err := app.Models.Category.Insert(category)
 	if err != nil {
 		app.errorJSON(w, err)
@@ -180,6 +668,12 @@ func (app *Config) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = category.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	updatedCategory, err := app.Models.Category.Update(category)
 	if err != nil {
 		app.errorJSON(w, err)
@@ -204,14 +698,82 @@ func (app *Config) UpdateCategoryWithID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = category.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	category.ID = categoryID
-	updatedCategory, err := app.Models.Category.Update(category)
+
+	if r.Header.Get("If-Match") != "" {
+		current, err := app.Models.Category.GetOne(categoryID, false)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		err = checkIfMatch(r, categoryETag(current))
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		// The update fails if the category changes before it is saved
+		category.Version = current.Version
+	}
+
+	_, err = app.Models.Category.Update(category)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, updatedCategory)
+	// Read back with its parents, whose versions are part of the entity tag
+	updatedCategory, err := app.Models.Category.GetOne(categoryID, false)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", categoryETag(updatedCategory))
+
+	app.writeJSON(w, http.StatusOK, updatedCategory, headers)
+}
+
+type moveCategoryRequest struct {
+	ParentCategoryID *int `json:"parentCategoryId"`
+}
+
+func (app *Config) MoveCategory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "categoryId")
+	categoryID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid category id"))
+		return
+	}
+
+	var req moveCategoryRequest
+	err = app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.Category.Move(categoryID, req.ParentCategoryID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	category, err := app.Models.Category.GetOne(categoryID, false)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, category)
 }
 
 func (app *Config) DeleteCategory(w http.ResponseWriter, r *http.Request) {
@@ -222,7 +784,13 @@ func (app *Config) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Category.Delete(categoryID)
+	policy, err := data.ParseDeletePolicy(r.URL.Query().Get("policy"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.Category.Delete(categoryID, policy)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -230,3 +798,26 @@ func (app *Config) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusOK, true)
 }
+
+func (app *Config) RestoreCategory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "categoryId")
+	categoryID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid category id"))
+		return
+	}
+
+	err = app.Models.Category.Restore(categoryID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	category, err := app.Models.Category.GetOne(categoryID, false)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, category)
+}
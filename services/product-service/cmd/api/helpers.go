@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"product/data"
 )
 
-type jsonResponse struct {
-	Error   bool        `json:"error"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+// problem is an RFC 7807 problem details response.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors maps each request field at fault to what is wrong with it.
+	Errors map[string]string `json:"errors,omitempty"`
 }
 
 func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
@@ -37,13 +51,14 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data interface{}
 		return err
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+
 	if len(headers) > 0 {
 		for key, value := range headers[0] {
 			w.Header()[key] = value
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 	if err != nil {
@@ -53,16 +68,229 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data interface{}
 	return nil
 }
 
+// errorJSON writes err as a problem details response. The status is picked
+// by errorStatus unless one is given. The details of server errors are
+// logged rather than sent.
 func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
+	err = data.Classify(err)
 
+	statusCode := errorStatus(err)
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
-	var payload jsonResponse
-	payload.Error = true
-	payload.Message = err.Error()
+	payload := problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: err.Error(),
+	}
+
+	var verr *data.ValidationError
+	if errors.As(err, &verr) {
+		payload.Errors = verr.Fields
+	}
+
+	if statusCode >= http.StatusInternalServerError {
+		log.Println("Error:", err)
+		payload.Detail = ""
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/problem+json")
+
+	return app.writeJSON(w, statusCode, payload, headers)
+}
+
+// errorStatus picks the HTTP status for an error. Errors from the data layer
+// are expected to have been through data.Classify.
+func errorStatus(err error) int {
+	var pgErr *pgconn.PgError
+
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, data.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, data.ErrInvalid):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, data.ErrCategoryCycle), errors.Is(err, data.ErrCategoryInUse),
+		errors.Is(err, data.ErrInsufficientStock), errors.Is(err, data.ErrReservationNotHeld),
+		errors.Is(err, data.ErrVariantInUse), errors.Is(err, data.ErrPriceScheduleConflict),
+		errors.Is(err, data.ErrPriceChangeEnded), errors.Is(err, data.ErrCategoryDeleted),
+		errors.Is(err, data.ErrProductDeleted), errors.Is(err, data.ErrStatusTransition),
+		errors.Is(err, data.ErrProductUnpublished), errors.Is(err, data.ErrDefaultWarehouse):
+		return http.StatusConflict
+	case errors.Is(err, data.ErrVersionMismatch), errors.Is(err, errPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errAuthRequired):
+		return http.StatusUnauthorized
+	case errors.Is(err, errMissingRole):
+		return http.StatusForbidden
+	case errors.Is(err, data.ErrUnsupportedImage), errors.Is(err, errUnsupportedPatch):
+		return http.StatusUnsupportedMediaType
+	case errors.As(err, &pgErr), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// checkQueryParams rejects any query string parameter not in allowed, so
+// that misspelled filters fail loudly instead of being ignored. An allowed
+// name ending in "*" admits any parameter with that prefix.
+func checkQueryParams(qs url.Values, allowed ...string) error {
+	for key := range qs {
+		ok := slices.ContainsFunc(allowed, func(a string) bool {
+			if prefix, found := strings.CutSuffix(a, "*"); found {
+				return strings.HasPrefix(key, prefix)
+			}
+			return a == key
+		})
+		if !ok {
+			return fmt.Errorf("unknown query parameter %q", key)
+		}
+	}
+	return nil
+}
+
+// errAuthRequired is returned when a public request asks for something only
+// an authenticated caller may see.
+var errAuthRequired = errors.New("authorization required")
+
+// readIncludeDeleted reads includeDeleted from the query string. Archived
+// products and categories are only shown to callers with catalog:write.
+func (app *Config) readIncludeDeleted(r *http.Request) (bool, error) {
+	return app.readCatalogWriterFlag(r, "includeDeleted")
+}
+
+// readPreview reads preview from the query string. Products that are not
+// published are only shown to callers with catalog:write.
+func (app *Config) readPreview(r *http.Request) (bool, error) {
+	return app.readCatalogWriterFlag(r, "preview")
+}
+
+// readCatalogWriterFlag reads a boolean query string parameter that only
+// callers with catalog:write may set.
+func (app *Config) readCatalogWriterFlag(r *http.Request, key string) (bool, error) {
+	flag, err := readOptionalBoolParam(r.URL.Query(), key)
+	if err != nil || flag == nil || !*flag {
+		return false, err
+	}
+
+	claims, err := app.authenticate(r)
+	if err != nil {
+		return false, fmt.Errorf("%w for %s: %v", errAuthRequired, key, err)
+	}
+	if !claims.HasRole(roleCatalogWrite) {
+		return false, fmt.Errorf("%w %s for %s", errMissingRole, roleCatalogWrite, key)
+	}
+
+	return true, nil
+}
+
+func readIntParam(qs url.Values, key string, defaultValue int) (int, error) {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+
+	return i, nil
+}
+
+func readOptionalIntParam(qs url.Values, key string) (*int, error) {
+	if qs.Get(key) == "" {
+		return nil, nil
+	}
+
+	i, err := readIntParam(qs, key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+func readOptionalFloatParam(qs url.Values, key string) (*float64, error) {
+	s := qs.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", key)
+	}
+
+	return &f, nil
+}
+
+// readOptionalMoneyParam reads an exact decimal amount, such as a price
+// bound, in currency.
+func readOptionalMoneyParam(qs url.Values, key, currency string) (*data.Money, error) {
+	s := qs.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+
+	m, err := data.ParseMoney(s, currency)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an amount such as 19.99", key)
+	}
+
+	return &m, nil
+}
+
+func readOptionalBoolParam(qs url.Values, key string) (*bool, error) {
+	s := qs.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", key)
+	}
+
+	return &b, nil
+}
+
+// paginationHeaders builds a Link header (RFC 8288) pointing at the first,
+// previous, next and last pages of a listing, plus an X-Total-Count header.
+func paginationHeaders(r *http.Request, page, size, total int) http.Header {
+	lastPage := (total + size - 1) / size
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	pageURL := func(p int) string {
+		u := *r.URL
+		qs := u.Query()
+		qs.Set("page", strconv.Itoa(p))
+		qs.Set("size", strconv.Itoa(size))
+		u.RawQuery = qs.Encode()
+		return u.RequestURI()
+	}
+
+	links := []string{
+		fmt.Sprintf(`<%s>; rel="first"`, pageURL(1)),
+	}
+	if page > 1 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(min(page-1, lastPage))))
+	}
+	if page < lastPage {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(page+1)))
+	}
+	links = append(links, fmt.Sprintf(`<%s>; rel="last"`, pageURL(lastPage)))
+
+	headers := http.Header{}
+	headers.Set("Link", strings.Join(links, ", "))
+	headers.Set("X-Total-Count", strconv.Itoa(total))
 
-	return app.writeJSON(w, statusCode, payload)
+	return headers
 }
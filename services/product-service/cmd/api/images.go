@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"product/blob"
+	"product/data"
+)
+
+// maxImageUploads caps the number of images sent in one upload.
+const maxImageUploads = 10
+
+type reorderImagesRequest struct {
+	ImageIDs []int `json:"imageIds"`
+}
+
+// readImageUploads reads the files sent in the "image" fields of a
+// multipart/form-data request.
+func readImageUploads(w http.ResponseWriter, r *http.Request) ([][]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageUploads*data.MaxImageBytes+(1<<20))
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("body must be multipart/form-data with one or more image fields")
+	}
+
+	var uploads [][]byte
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != "image" {
+			return nil, fmt.Errorf("unknown form field %q", part.FormName())
+		}
+		if len(uploads) == maxImageUploads {
+			return nil, fmt.Errorf("at most %d images can be uploaded at once", maxImageUploads)
+		}
+
+		upload, err := io.ReadAll(io.LimitReader(part, data.MaxImageBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(upload) > data.MaxImageBytes {
+			return nil, fmt.Errorf("image %d is larger than %d MB", len(uploads)+1, data.MaxImageBytes>>20)
+		}
+
+		uploads = append(uploads, upload)
+	}
+
+	if len(uploads) == 0 {
+		return nil, errors.New("missing image field")
+	}
+
+	return uploads, nil
+}
+
+func (app *Config) GetProductImages(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	err = app.checkProduct(r, productID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	images, err := app.Models.Image.GetAll(productID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: images,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) UploadProductImages(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	uploads, err := readImageUploads(w, r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	images, err := app.Models.Image.Add(productID, uploads...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: images,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+func (app *Config) ReorderProductImages(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	var req reorderImagesRequest
+	err = app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	images, err := app.Models.Image.Reorder(productID, req.ImageIDs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: images,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) DeleteProductImage(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	imageID, err := strconv.Atoi(chi.URLParam(r, "imageId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid image id"))
+		return
+	}
+
+	err = app.Models.Image.Delete(productID, imageID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, true)
+}
+
+func (app *Config) UploadCategoryImage(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := strconv.Atoi(chi.URLParam(r, "categoryId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid category id"))
+		return
+	}
+
+	uploads, err := readImageUploads(w, r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if len(uploads) > 1 {
+		app.errorJSON(w, errors.New("a category has a single image"))
+		return
+	}
+
+	err = app.Models.Image.SetCategoryImage(categoryID, uploads[0])
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.GetCategory(w, r)
+}
+
+func (app *Config) DeleteCategoryImage(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := strconv.Atoi(chi.URLParam(r, "categoryId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid category id"))
+		return
+	}
+
+	err = app.Models.Image.DeleteCategoryImage(categoryID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, true)
+}
+
+// ServeImage serves stored images and thumbnails. Their keys are never
+// reused for different contents, so they can be cached indefinitely.
+func (app *Config) ServeImage(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	if !blob.ValidKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := app.Models.Image.Store.Open(r.Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", time.Time{}, rs)
+		return
+	}
+
+	io.Copy(w, f)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"product/data"
+)
+
+const (
+	// maxImportBytes caps the size of an import file.
+	maxImportBytes = 100 << 20
+
+	// syncImportBytes is the largest import run while the request waits.
+	// Larger ones run as a background job.
+	syncImportBytes = 1 << 20
+)
+
+// importFormats maps the content types an import may be sent as to its format.
+var importFormats = map[string]string{
+	"text/csv":             data.ImportFormatCSV,
+	"application/x-ndjson": data.ImportFormatNDJSON,
+	"application/jsonl":    data.ImportFormatNDJSON,
+}
+
+// ImportProducts upserts products from a CSV or NDJSON file sent as the
+// request body. The file is spooled to disk first, so a background job can
+// read it after the request has returned.
+func (app *Config) ImportProducts(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	err := checkQueryParams(qs, "format", "dryRun")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	format := qs.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = importFormats[mediaType]
+	}
+	if format == "" {
+		app.errorJSON(w, errors.New("format must be csv or ndjson, or given by Content-Type"))
+		return
+	}
+
+	dryRun, err := readOptionalBoolParam(qs, "dryRun")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	file, err := os.CreateTemp("", "product-import-*")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	size, err := io.Copy(file, http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		closeImportFile(file)
+		app.errorJSON(w, fmt.Errorf("reading import file: %w", err))
+		return
+	}
+
+	job, err := app.Models.Import.CreateJob(format, dryRun != nil && *dryRun)
+	if err != nil {
+		closeImportFile(file)
+		app.errorJSON(w, err)
+		return
+	}
+
+	if size <= syncImportBytes {
+		defer closeImportFile(file)
+
+		err = app.Models.Import.Run(job, file)
+		if err != nil && job.Status != data.ImportFailed {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	go func() {
+		defer closeImportFile(file)
+
+		err := app.Models.Import.Run(job, file)
+		if err != nil {
+			log.Printf("Import job %d failed: %v\n", job.ID, err)
+		}
+	}()
+
+	headers := http.Header{}
+	headers.Set("Location", "/product-service/api/products/import/"+strconv.Itoa(job.ID))
+
+	app.writeJSON(w, http.StatusAccepted, job, headers)
+}
+
+func (app *Config) GetImportJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(chi.URLParam(r, "jobId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid import job id"))
+		return
+	}
+
+	job, err := app.Models.Import.GetJob(jobID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, job)
+}
+
+func closeImportFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"product/auth"
+	"product/data"
+)
+
+type inventoryMovementRequest struct {
+	VariantID   *int   `json:"variantId"`
+	WarehouseID *int   `json:"warehouseId"`
+	Change      int    `json:"change"`
+	Reason      string `json:"reason"`
+	Actor       string `json:"actor"`
+	ReferenceID string `json:"referenceId"`
+}
+
+func (app *Config) PostInventoryMovement(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	var req inventoryMovementRequest
+	err = app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Reservation movements are only ever made by confirming a reservation,
+	// and transfer movements in pairs by a transfer
+	if req.Reason == data.ReasonReservation {
+		app.errorJSON(w, errors.New("reservation movements are recorded by confirming a reservation"))
+		return
+	}
+	if req.Reason == data.ReasonTransfer {
+		app.errorJSON(w, errors.New("transfer movements are recorded by a transfer between warehouses"))
+		return
+	}
+
+	// A movement that names no actor is put down to the caller
+	actor := req.Actor
+	if claims, ok := auth.FromContext(r.Context()); ok && actor == "" {
+		actor = claims.Actor()
+	}
+
+	movement, err := app.Models.Inventory.Record(data.InventoryMovement{
+		ProductID:   productID,
+		VariantID:   req.VariantID,
+		WarehouseID: req.WarehouseID,
+		Change:      req.Change,
+		Reason:      req.Reason,
+		Actor:       actor,
+		ReferenceID: req.ReferenceID,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, movement)
+}
+
+func (app *Config) GetInventoryMovements(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	qs := r.URL.Query()
+
+	err = checkQueryParams(qs, "page", "size")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	opts, err := app.readListOptions(qs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	movements, total, err := app.Models.Inventory.History(productID, opts)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoPageResponse{
+		Collection:    movements,
+		Page:          opts.Page,
+		Size:          opts.Size,
+		TotalElements: total,
+		TotalPages:    (total + opts.Size - 1) / opts.Size,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload, paginationHeaders(r, opts.Page, opts.Size, total))
+}
+
+func (app *Config) GetStockLevel(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	level, err := app.Models.Inventory.StockLevel(productID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, level)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"product/data"
+)
+
+type productLookupRequest struct {
+	IDs  []int    `json:"ids"`
+	SKUs []string `json:"skus"`
+}
+
+// GetProductBySKU gets a product by its SKU, or the SKU of one of its
+// variants, matched without regard to case.
+func (app *Config) GetProductBySKU(w http.ResponseWriter, r *http.Request) {
+	err := checkQueryParams(r.URL.Query(), "currency", "priceList", "preview")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	product, err := app.Models.Product.GetBySKU(chi.URLParam(r, "sku"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeProduct(w, r, product)
+}
+
+// LookupProducts gets many products at once by ID or SKU, for callers that
+// would otherwise get them one at a time.
+func (app *Config) LookupProducts(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	err := checkQueryParams(qs, "currency", "priceList", "preview")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var req productLookupRequest
+	err = app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	n := len(req.IDs) + len(req.SKUs)
+	if n == 0 {
+		app.errorJSON(w, errors.New("lookup must ask for at least one id or sku"))
+		return
+	}
+	if n > data.MaxLookupSize {
+		app.errorJSON(w, fmt.Errorf("lookup may ask for at most %d ids and skus", data.MaxLookupSize))
+		return
+	}
+	for _, sku := range req.SKUs {
+		if strings.TrimSpace(sku) == "" {
+			app.errorJSON(w, errors.New("lookup skus must not be empty"))
+			return
+		}
+	}
+
+	preview, err := app.readPreview(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	result, err := app.Models.Product.Lookup(req.IDs, req.SKUs, preview)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.applyPrices(r, result.Products...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.Image.Load(result.Products...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, result)
+}
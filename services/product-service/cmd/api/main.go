@@ -1,23 +1,44 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"product/auth"
+	"product/blob"
 	"product/data"
+	"product/event"
 )
 
 const webPort = "80"
 
+const reservationSweepInterval = 30 * time.Second
+
+const priceScheduleInterval = 30 * time.Second
+
+const publishScheduleInterval = 30 * time.Second
+
+const archivePurgeInterval = time.Hour
+
+// defaultArchiveRetentionDays is how long an archived product or category is
+// kept before it is purged, unless ARCHIVE_RETENTION_DAYS says otherwise.
+const defaultArchiveRetentionDays = 30
+
 type Config struct {
 	Models data.Models
+	// Verifier checks the bearer tokens of protected routes.
+	Verifier *auth.Verifier
+	// StorefrontURL is the base of the product page links in exported feeds.
+	StorefrontURL string
 }
 
 func main() {
@@ -34,10 +55,70 @@ func main() {
 		log.Panic(err)
 	}
 
+	imageDir := os.Getenv("IMAGE_DIR")
+	if imageDir == "" {
+		imageDir = "images"
+	}
+	imageBaseURL := os.Getenv("IMAGE_BASE_URL")
+	if imageBaseURL == "" {
+		imageBaseURL = "/product-service/images"
+	}
+
+	store, err := blob.NewLocalStore(imageDir, imageBaseURL)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	retentionDays := defaultArchiveRetentionDays
+	if v := os.Getenv("ARCHIVE_RETENTION_DAYS"); v != "" {
+		retentionDays, err = strconv.Atoi(v)
+		if err != nil || retentionDays < 1 {
+			log.Panicf("ARCHIVE_RETENTION_DAYS must be a positive number of days, got %q", v)
+		}
+	}
+
+	verifier, err := tokenVerifier()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	app := Config{
-		Models: data.New(conn),
+		Models:        data.New(conn, store),
+		Verifier:      verifier,
+		StorefrontURL: os.Getenv("STOREFRONT_URL"),
+	}
+
+	n, err := app.Models.Import.FailInterrupted()
+	if err != nil {
+		log.Panic(err)
+	}
+	if n > 0 {
+		log.Printf("Marked %d interrupted import jobs as failed\n", n)
+	}
+
+	var publisher event.Publisher = event.LogPublisher{}
+	if url := os.Getenv("RABBITMQ_URL"); url != "" {
+		rabbit, err := event.Connect(url)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer rabbit.Close()
+		publisher = rabbit
+	} else {
+		log.Println("RABBITMQ_URL not set, events will only be logged")
 	}
 
+	relay := event.NewRelay(&app.Models.Outbox, publisher)
+	go relay.Run(context.Background())
+
+	go app.sweepReservations(reservationSweepInterval)
+
+	go app.applyScheduledPrices(priceScheduleInterval)
+
+	go app.applyPublishSchedule(publishScheduleInterval)
+
+	go app.purgeArchived(archivePurgeInterval, time.Duration(retentionDays)*24*time.Hour)
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", webPort),
 		Handler: app.routes(),
@@ -50,6 +131,30 @@ func main() {
 	}
 }
 
+// tokenVerifier configures token verification from the environment: a
+// JWT_SECRET for HS256 tokens or a JWT_JWKS file or URL for RS256 ones, and
+// the JWT_ISSUER and JWT_AUDIENCE every token must carry.
+func tokenVerifier() (*auth.Verifier, error) {
+	cfg := auth.Config{
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		cfg.Secret = []byte(secret)
+	}
+
+	if source := os.Getenv("JWT_JWKS"); source != "" {
+		keys, err := auth.LoadKeySet(source)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Keys = keys
+	}
+
+	return auth.NewVerifier(cfg)
+}
+
 func openDB(dsn string) (*sql.DB, error) {
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
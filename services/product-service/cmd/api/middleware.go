@@ -2,35 +2,74 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+
+	"product/auth"
+)
+
+// Roles a token must grant for the protected routes.
+const (
+	// roleCatalogWrite manages products, categories and prices.
+	roleCatalogWrite = "catalog:write"
+	// roleInventoryWrite moves stock and holds reservations.
+	roleInventoryWrite = "inventory:write"
 )
 
+// errMissingRole is returned when a token is valid but does not grant the
+// role a request needs.
+var errMissingRole = errors.New("forbidden - missing role")
+
+// Auth verifies the request's bearer token and puts its claims in the
+// request context.
 func (app *Config) Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check for Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.errorJSON(w, errors.New("missing authorization header"), http.StatusUnauthorized)
+		claims, err := app.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			app.errorJSON(w, err, http.StatusUnauthorized)
 			return
 		}
 
-		// Split the header
-		headerParts := strings.Split(authHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.errorJSON(w, errors.New("invalid authorization header"), http.StatusUnauthorized)
-			return
-		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), claims)))
+	})
+}
 
-		token := headerParts[1]
+// RequireRole refuses requests whose token does not grant role. It goes
+// after Auth.
+func (app *Config) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := auth.FromContext(r.Context())
+			if !ok || !claims.HasRole(role) {
+				app.errorJSON(w, fmt.Errorf("%w %s", errMissingRole, role), http.StatusForbidden)
+				return
+			}
 
-		// For demonstration, we consider "admin-token" as a valid admin token
-		// In next Phase we will communicate with auth-service to validate tokens
-		if token != "admin-token" {
-			app.errorJSON(w, errors.New("unauthorized - admin access required"), http.StatusUnauthorized)
-			return
-		}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		next.ServeHTTP(w, r)
-	})
+// authenticate verifies the bearer token r carries and returns its claims.
+func (app *Config) authenticate(r *http.Request) (*auth.Claims, error) {
+	// Check for Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, errors.New("missing authorization header")
+	}
+
+	// Split the header
+	headerParts := strings.Split(authHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return nil, errors.New("invalid authorization header")
+	}
+
+	claims, err := app.Verifier.Verify(headerParts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	return claims, nil
 }
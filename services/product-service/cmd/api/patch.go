@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"product/data"
+)
+
+// productPatchFields and categoryPatchFields are the fields a merge patch
+// may set. The rest are read-only or managed through their own endpoints.
+var (
+	productPatchFields  = []string{"productTitle", "imageUrl", "sku", "priceUnit", "quantity", "lowStockThreshold", "category", "attributes"}
+	categoryPatchFields = []string{"categoryTitle", "imageUrl", "parentCategory"}
+)
+
+// errUnsupportedPatch is returned for a PATCH body that is not a JSON merge
+// patch.
+var errUnsupportedPatch = errors.New("PATCH body must be application/merge-patch+json")
+
+// readMergePatch reads an RFC 7396 JSON merge patch from the request body.
+// Only patches that are JSON objects are accepted, as a patch of any other
+// kind would replace the whole resource.
+func (app *Config) readMergePatch(w http.ResponseWriter, r *http.Request) (map[string]any, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		return nil, errUnsupportedPatch
+	}
+
+	var patch any
+	err := app.readJSON(w, r, &patch)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, ok := patch.(map[string]any)
+	if !ok {
+		return nil, errors.New("merge patch must be a JSON object")
+	}
+
+	return obj, nil
+}
+
+// applyMergePatch applies patch to the given fields of current and decodes
+// the result into out. Fields outside of fields are left out of the result,
+// and a patch that sets one is rejected.
+func applyMergePatch(current any, patch map[string]any, out any, fields ...string) error {
+	for key := range patch {
+		if !slices.Contains(fields, key) {
+			return fmt.Errorf("field %q cannot be patched", key)
+		}
+	}
+
+	b, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	// Numbers are kept as written, so that prices are not rounded through
+	// float64
+	var doc map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&doc)
+	if err != nil {
+		return err
+	}
+
+	target := map[string]any{}
+	for _, field := range fields {
+		if v, ok := doc[field]; ok {
+			target[field] = v
+		}
+	}
+
+	b, err = json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return err
+	}
+
+	dec = json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(out)
+}
+
+// mergePatch merges patch into target as RFC 7396 describes: members of an
+// object patch are merged recursively, null removes a member, and anything
+// else replaces the target outright.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], value)
+		}
+	}
+
+	return t
+}
+
+// PatchProduct changes the fields of a product present in a merge patch,
+// leaving the rest as they are.
+func (app *Config) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	patch, err := app.readMergePatch(w, r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	current, err := app.Models.Product.GetOne(productID, false)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = checkIfMatch(r, productETag(current))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var product data.Product
+	err = applyMergePatch(current, patch, &product, productPatchFields...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = product.ValidateUpdate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// The patch was applied to what was read, so the update fails rather
+	// than overwrite a change made since
+	product.ID = productID
+	product.Version = current.Version
+
+	updatedProduct, err := app.Models.Product.Update(product)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", productETag(updatedProduct))
+
+	app.writeJSON(w, http.StatusOK, updatedProduct, headers)
+}
+
+// PatchCategory changes the fields of a category present in a merge patch,
+// leaving the rest as they are.
+func (app *Config) PatchCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := strconv.Atoi(chi.URLParam(r, "categoryId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid category id"))
+		return
+	}
+
+	patch, err := app.readMergePatch(w, r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	current, err := app.Models.Category.GetOne(categoryID, false)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = checkIfMatch(r, categoryETag(current))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var category data.Category
+	err = applyMergePatch(current, patch, &category, categoryPatchFields...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = category.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// The patch was applied to what was read, so the update fails rather
+	// than overwrite a change made since
+	category.ID = categoryID
+	category.Version = current.Version
+
+	_, err = app.Models.Category.Update(category)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Read back with its parents, whose versions are part of the entity tag
+	updatedCategory, err := app.Models.Category.GetOne(categoryID, false)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", categoryETag(updatedCategory))
+
+	app.writeJSON(w, http.StatusOK, updatedCategory, headers)
+}
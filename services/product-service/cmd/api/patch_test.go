@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestMergePatch runs the examples of RFC 7396, appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	decode := func(s string) any {
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.patch, func(t *testing.T) {
+			got := mergePatch(decode(tt.target), decode(tt.patch))
+			if want := decode(tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("mergePatch() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	type item struct {
+		Title string            `json:"title"`
+		Price json.Number       `json:"price"`
+		Tags  map[string]string `json:"tags"`
+		Owner string            `json:"owner"`
+	}
+
+	current := item{
+		Title: "Mug",
+		Price: "12.10",
+		Tags:  map[string]string{"colour": "red", "size": "large"},
+		Owner: "alice",
+	}
+	fields := []string{"title", "price", "tags"}
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    item
+		wantErr bool
+	}{
+		{"empty patch", `{}`, item{Title: "Mug", Price: "12.10", Tags: map[string]string{"colour": "red", "size": "large"}}, false},
+		{"replace", `{"title": "Cup"}`, item{Title: "Cup", Price: "12.10", Tags: map[string]string{"colour": "red", "size": "large"}}, false},
+		{"number kept as written", `{"price": 45.99}`, item{Title: "Mug", Price: "45.99", Tags: map[string]string{"colour": "red", "size": "large"}}, false},
+		{"nested merge", `{"tags": {"size": null, "shape": "round"}}`, item{Title: "Mug", Price: "12.10", Tags: map[string]string{"colour": "red", "shape": "round"}}, false},
+		{"remove member", `{"tags": null}`, item{Title: "Mug", Price: "12.10"}, false},
+		{"field not patchable", `{"owner": "bob"}`, item{}, true},
+		{"unknown field", `{"colour": "blue"}`, item{}, true},
+		{"wrong type", `{"title": 5}`, item{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch map[string]any
+			if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+				t.Fatal(err)
+			}
+
+			var got item
+			err := applyMergePatch(current, patch, &got, fields...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyMergePatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyMergePatch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"product/data"
+)
+
+// lowestPriceDays is the default window for the lowest price reported with
+// a product's price history, the 30 days price reduction notices refer to.
+const lowestPriceDays = 30
+
+type schedulePriceRequest struct {
+	Price         data.Money `json:"price"`
+	EffectiveFrom time.Time  `json:"effectiveFrom"`
+	EffectiveTo   *time.Time `json:"effectiveTo"`
+}
+
+func (app *Config) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	qs := r.URL.Query()
+
+	err = checkQueryParams(qs, "days", "includeDeleted", "preview")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkProduct(r, productID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	days, err := readIntParam(qs, "days", lowestPriceDays)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if days < 1 || days > 365 {
+		app.errorJSON(w, errors.New("days must be between 1 and 365"))
+		return
+	}
+
+	history, err := app.Models.Product.PriceHistory(productID, days)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, history)
+}
+
+func (app *Config) SchedulePrice(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	var req schedulePriceRequest
+	err = app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	change, err := app.Models.Product.SchedulePrice(data.PriceChange{
+		ProductID:     productID,
+		Price:         req.Price,
+		EffectiveFrom: req.EffectiveFrom,
+		EffectiveTo:   req.EffectiveTo,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, change)
+}
+
+func (app *Config) CancelPriceChange(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	changeID, err := strconv.ParseInt(chi.URLParam(r, "priceChangeId"), 10, 64)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid price change id"))
+		return
+	}
+
+	err = app.Models.Product.CancelPriceChange(productID, changeID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, true)
+}
+
+// applyScheduledPrices periodically starts and ends scheduled price changes.
+// It runs for the life of the process.
+func (app *Config) applyScheduledPrices(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := app.Models.Product.ApplyScheduledPrices()
+		if err != nil {
+			log.Println("Error applying scheduled prices:", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Repriced %d products from scheduled price changes\n", n)
+		}
+	}
+}
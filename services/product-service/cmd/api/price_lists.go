@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"product/data"
+)
+
+type exchangeRateRequest struct {
+	Rate string `json:"rate"`
+}
+
+// readPriceSelector reads the currency and priceList selectors from the
+// query string, and the customer group from the caller's token if there is
+// one. Anonymous callers are in no group.
+func (app *Config) readPriceSelector(r *http.Request) (data.PriceSelector, error) {
+	qs := r.URL.Query()
+	sel := data.PriceSelector{
+		Currency:  qs.Get("currency"),
+		PriceList: qs.Get("priceList"),
+	}
+
+	err := sel.Validate()
+	if err != nil || sel.IsZero() || r.Header.Get("Authorization") == "" {
+		return sel, err
+	}
+
+	claims, err := app.authenticate(r)
+	if err != nil {
+		return sel, fmt.Errorf("%w for customer group prices: %v", errAuthRequired, err)
+	}
+	sel.CustomerGroup = claims.CustomerGroup
+
+	return sel, nil
+}
+
+// applyPrices sets the selling price of products as selected by the
+// request, if it selects one.
+func (app *Config) applyPrices(r *http.Request, products ...*data.Product) error {
+	sel, err := app.readPriceSelector(r)
+	if err != nil {
+		return err
+	}
+	if sel.IsZero() {
+		return nil
+	}
+	return app.Models.PriceList.Apply(sel, products)
+}
+
+// readPriceListViewer reads who may see which price lists: callers with
+// catalog:write see them all, and everyone else those for everyone and for
+// the customer group in their token, if they send one.
+func (app *Config) readPriceListViewer(r *http.Request) (customerGroup string, all bool, err error) {
+	if r.Header.Get("Authorization") == "" {
+		return "", false, nil
+	}
+
+	claims, err := app.authenticate(r)
+	if err != nil {
+		return "", false, fmt.Errorf("%w for customer group price lists: %v", errAuthRequired, err)
+	}
+
+	return claims.CustomerGroup, claims.HasRole(roleCatalogWrite), nil
+}
+
+// getVisiblePriceList gets the price list with the given id if the caller
+// may see it, and sql.ErrNoRows otherwise.
+func (app *Config) getVisiblePriceList(r *http.Request, id int) (*data.PriceList, error) {
+	group, all, err := app.readPriceListViewer(r)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := app.Models.PriceList.GetOne(id)
+	if err != nil {
+		return nil, err
+	}
+	if !all && !list.VisibleTo(group) {
+		return nil, sql.ErrNoRows
+	}
+
+	return list, nil
+}
+
+func readPriceListID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "priceListId"))
+	if err != nil {
+		return 0, errors.New("invalid price list id")
+	}
+	return id, nil
+}
+
+// GetAllPriceLists gets the price lists the caller may see.
+func (app *Config) GetAllPriceLists(w http.ResponseWriter, r *http.Request) {
+	group, all, err := app.readPriceListViewer(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	lists, err := app.Models.PriceList.GetAll()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	visible := []*data.PriceList{}
+	for _, list := range lists {
+		if all || list.VisibleTo(group) {
+			visible = append(visible, list)
+		}
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: visible,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) GetPriceList(w http.ResponseWriter, r *http.Request) {
+	priceListID, err := readPriceListID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	list, err := app.getVisiblePriceList(r, priceListID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, list)
+}
+
+func (app *Config) CreatePriceList(w http.ResponseWriter, r *http.Request) {
+	var list data.PriceList
+	err := app.readJSON(w, r, &list)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	newList, err := app.Models.PriceList.Insert(list)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, newList)
+}
+
+func (app *Config) UpdatePriceList(w http.ResponseWriter, r *http.Request) {
+	priceListID, err := readPriceListID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var list data.PriceList
+	err = app.readJSON(w, r, &list)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	list.ID = priceListID
+
+	updatedList, err := app.Models.PriceList.Update(list)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, updatedList)
+}
+
+func (app *Config) DeletePriceList(w http.ResponseWriter, r *http.Request) {
+	priceListID, err := readPriceListID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.PriceList.Delete(priceListID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, true)
+}
+
+func (app *Config) GetPriceListPrices(w http.ResponseWriter, r *http.Request) {
+	priceListID, err := readPriceListID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = checkQueryParams(r.URL.Query(), "preview")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	preview, err := app.readPreview(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, err = app.getVisiblePriceList(r, priceListID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	entries, err := app.Models.PriceList.Prices(priceListID, preview)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: entries,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) SetPriceListPrices(w http.ResponseWriter, r *http.Request) {
+	priceListID, err := readPriceListID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var entries []data.PriceListEntry
+	err = app.readJSON(w, r, &entries)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.PriceList.SetPrices(priceListID, entries)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.GetPriceListPrices(w, r)
+}
+
+func (app *Config) DeletePriceListPrice(w http.ResponseWriter, r *http.Request) {
+	priceListID, err := readPriceListID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	variantID, err := readOptionalIntParam(r.URL.Query(), "variantId")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.PriceList.DeletePrice(priceListID, productID, variantID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, true)
+}
+
+func (app *Config) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.Models.PriceList.ExchangeRates()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: rates,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) SetExchangeRate(w http.ResponseWriter, r *http.Request) {
+	var req exchangeRateRequest
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	rate, err := app.Models.PriceList.SetExchangeRate(data.ExchangeRate{
+		Base:  chi.URLParam(r, "base"),
+		Quote: chi.URLParam(r, "quote"),
+		Rate:  req.Rate,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, rate)
+}
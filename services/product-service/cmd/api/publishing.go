@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"product/data"
+)
+
+// SetProductStatus moves a product through its lifecycle and sets when it
+// is to be published or unpublished.
+func (app *Config) SetProductStatus(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	var change data.StatusChange
+	err = app.readJSON(w, r, &change)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.Product.SetStatus(productID, change)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	product, err := app.Models.Product.GetOne(productID, false)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, product)
+}
+
+// applyPublishSchedule periodically publishes and unpublishes products whose
+// scheduled time has come. It runs for the life of the process.
+func (app *Config) applyPublishSchedule(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		published, unpublished, err := app.Models.Product.ApplyPublishSchedule()
+		if err != nil {
+			log.Println("Error applying publish schedule:", err)
+			continue
+		}
+		if published > 0 || unpublished > 0 {
+			log.Printf("Published %d and unpublished %d products on schedule\n", published, unpublished)
+		}
+	}
+}
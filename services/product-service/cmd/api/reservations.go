@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"product/data"
+)
+
+const (
+	defaultReservationTTL = 15 * time.Minute
+	maxReservationTTL     = 24 * time.Hour
+)
+
+type holdReservationRequest struct {
+	Reference  string                 `json:"reference"`
+	TTLSeconds int                    `json:"ttlSeconds"`
+	Items      []data.ReservationItem `json:"items"`
+}
+
+func (app *Config) HoldReservation(w http.ResponseWriter, r *http.Request) {
+	var req holdReservationRequest
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if req.Reference == "" {
+		app.errorJSON(w, errors.New("reference is required"))
+		return
+	}
+
+	ttl := defaultReservationTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > maxReservationTTL {
+		app.errorJSON(w, fmt.Errorf("ttlSeconds must be between 1 and %d", int(maxReservationTTL.Seconds())))
+		return
+	}
+
+	reservation, err := app.Models.Reservation.Hold(req.Reference, req.Items, time.Now().Add(ttl))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, reservation)
+}
+
+func (app *Config) GetReservation(w http.ResponseWriter, r *http.Request) {
+	reservationID, err := app.readReservationID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	reservation, err := app.Models.Reservation.GetOne(reservationID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, reservation)
+}
+
+func (app *Config) ConfirmReservation(w http.ResponseWriter, r *http.Request) {
+	reservationID, err := app.readReservationID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	reservation, err := app.Models.Reservation.Confirm(reservationID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, reservation)
+}
+
+func (app *Config) ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	reservationID, err := app.readReservationID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	reservation, err := app.Models.Reservation.Release(reservationID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, reservation)
+}
+
+func (app *Config) readReservationID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "reservationId"))
+	if err != nil {
+		return 0, errors.New("invalid reservation id")
+	}
+	return id, nil
+}
+
+// sweepReservations periodically returns the stock of expired holds. It runs
+// for the life of the process.
+func (app *Config) sweepReservations(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := app.Models.Reservation.ExpireHeld()
+		if err != nil {
+			log.Println("Error expiring reservations:", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Expired %d stock reservations\n", n)
+		}
+	}
+}
@@ -13,9 +13,9 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", "If-None-Match"},
+		ExposedHeaders:   []string{"Link", "X-Total-Count", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -23,31 +23,124 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Route("/product-service", func(r chi.Router) {
+		r.Get("/images/*", app.ServeImage)
+
 		r.Route("/api/products", func(r chi.Router) {
 			r.Get("/", app.GetAllProducts)
+			r.Get("/search", app.SearchProducts)
+			r.Get("/export", app.ExportProducts)
+			r.Get("/changes", app.GetChanges)
+			r.Get("/by-sku/{sku}", app.GetProductBySKU)
+			r.Post("/lookup", app.LookupProducts)
 			r.Get("/{productId}", app.GetProduct)
+			r.Get("/{productId}/variants", app.GetVariants)
+			r.Get("/{productId}/price-history", app.GetPriceHistory)
+			r.Get("/{productId}/images", app.GetProductImages)
 
 			// Protected routes
 			r.Group(func(r chi.Router) {
 				r.Use(app.Auth)
+				r.Use(app.RequireRole(roleCatalogWrite))
 				r.Post("/", app.CreateProduct)
 				r.Put("/", app.UpdateProduct)
 				r.Put("/{productId}", app.UpdateProductWithID)
+				r.Patch("/{productId}", app.PatchProduct)
 				r.Delete("/{productId}", app.DeleteProduct)
+				r.Post("/{productId}/restore", app.RestoreProduct)
+				r.Put("/{productId}/status", app.SetProductStatus)
+
+				r.Post("/import", app.ImportProducts)
+				r.Get("/import/{jobId}", app.GetImportJob)
+
+				r.Put("/{productId}/options", app.SetProductOptions)
+				r.Put("/{productId}/variants/{variantId}", app.UpdateVariant)
+
+				r.Post("/{productId}/images", app.UploadProductImages)
+				r.Put("/{productId}/images/order", app.ReorderProductImages)
+				r.Delete("/{productId}/images/{imageId}", app.DeleteProductImage)
+
+				r.Post("/{productId}/price-changes", app.SchedulePrice)
+				r.Delete("/{productId}/price-changes/{priceChangeId}", app.CancelPriceChange)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.Auth)
+				r.Use(app.RequireRole(roleInventoryWrite))
+				r.Get("/{productId}/movements", app.GetInventoryMovements)
+				r.Post("/{productId}/movements", app.PostInventoryMovement)
+				r.Get("/{productId}/stock", app.GetStockLevel)
+
+				r.Post("/reservations", app.HoldReservation)
+				r.Get("/reservations/{reservationId}", app.GetReservation)
+				r.Post("/reservations/{reservationId}/confirm", app.ConfirmReservation)
+				r.Post("/reservations/{reservationId}/release", app.ReleaseReservation)
+			})
+		})
+
+		r.Route("/api/price-lists", func(r chi.Router) {
+			r.Get("/", app.GetAllPriceLists)
+			r.Get("/{priceListId}", app.GetPriceList)
+			r.Get("/{priceListId}/prices", app.GetPriceListPrices)
+
+			// Protected routes
+			r.Group(func(r chi.Router) {
+				r.Use(app.Auth)
+				r.Use(app.RequireRole(roleCatalogWrite))
+				r.Post("/", app.CreatePriceList)
+				r.Put("/{priceListId}", app.UpdatePriceList)
+				r.Delete("/{priceListId}", app.DeletePriceList)
+				r.Put("/{priceListId}/prices", app.SetPriceListPrices)
+				r.Delete("/{priceListId}/prices/{productId}", app.DeletePriceListPrice)
+			})
+		})
+
+		r.Route("/api/warehouses", func(r chi.Router) {
+			r.Get("/", app.GetAllWarehouses)
+			r.Get("/{warehouseId}", app.GetWarehouse)
+
+			// Protected routes
+			r.Group(func(r chi.Router) {
+				r.Use(app.Auth)
+				r.Use(app.RequireRole(roleInventoryWrite))
+				r.Post("/", app.CreateWarehouse)
+				r.Put("/{warehouseId}", app.UpdateWarehouse)
+				r.Post("/transfers", app.TransferStock)
+				r.Post("/allocations", app.AllocateStock)
+			})
+		})
+
+		r.Route("/api/exchange-rates", func(r chi.Router) {
+			r.Get("/", app.GetExchangeRates)
+
+			// Protected routes
+			r.Group(func(r chi.Router) {
+				r.Use(app.Auth)
+				r.Use(app.RequireRole(roleCatalogWrite))
+				r.Put("/{base}/{quote}", app.SetExchangeRate)
 			})
 		})
 
 		r.Route("/api/categories", func(r chi.Router) {
 			r.Get("/", app.GetAllCategories)
+			r.Get("/tree", app.GetCategoryTree)
 			r.Get("/{categoryId}", app.GetCategory)
+			r.Get("/{categoryId}/breadcrumbs", app.GetCategoryBreadcrumbs)
+			r.Get("/{categoryId}/attributes", app.GetCategoryAttributes)
 
 			// Protected routes
 			r.Group(func(r chi.Router) {
 				r.Use(app.Auth)
+				r.Use(app.RequireRole(roleCatalogWrite))
 				r.Post("/", app.CreateCategory)
 				r.Put("/", app.UpdateCategory)
 				r.Put("/{categoryId}", app.UpdateCategoryWithID)
+				r.Patch("/{categoryId}", app.PatchCategory)
+				r.Post("/{categoryId}/move", app.MoveCategory)
+				r.Put("/{categoryId}/attributes", app.SetCategoryAttributes)
+				r.Put("/{categoryId}/image", app.UploadCategoryImage)
+				r.Delete("/{categoryId}/image", app.DeleteCategoryImage)
 				r.Delete("/{categoryId}", app.DeleteCategory)
+				r.Post("/{categoryId}/restore", app.RestoreCategory)
 			})
 		})
 	})
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"product/data"
+)
+
+type setOptionsRequest struct {
+	Options []data.ProductOption `json:"options"`
+}
+
+type variantsResponse struct {
+	Options  []data.ProductOption   `json:"options"`
+	Variants []*data.ProductVariant `json:"variants"`
+}
+
+func (app *Config) GetVariants(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	err = app.checkProduct(r, productID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	options, err := app.Models.Variant.Options(productID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	variants, err := app.Models.Variant.GetAll(productID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, variantsResponse{Options: options, Variants: variants})
+}
+
+func (app *Config) SetProductOptions(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	var req setOptionsRequest
+	err = app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	variants, err := app.Models.Variant.SetOptions(productID, req.Options)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, variantsResponse{Options: req.Options, Variants: variants})
+}
+
+func (app *Config) UpdateVariant(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "productId")
+	productID, err := strconv.Atoi(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product id"))
+		return
+	}
+
+	variantID, err := strconv.Atoi(chi.URLParam(r, "variantId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid variant id"))
+		return
+	}
+
+	var variant data.ProductVariant
+	err = app.readJSON(w, r, &variant)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	variant.ID = variantID
+	variant.ProductID = productID
+	updatedVariant, err := app.Models.Variant.Update(variant)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, updatedVariant)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"product/auth"
+	"product/data"
+)
+
+func readWarehouseID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "warehouseId"))
+	if err != nil {
+		return 0, errors.New("invalid warehouse id")
+	}
+	return id, nil
+}
+
+func (app *Config) GetAllWarehouses(w http.ResponseWriter, r *http.Request) {
+	warehouses, err := app.Models.Warehouse.GetAll()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := DtoCollectionResponse{
+		Collection: warehouses,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) GetWarehouse(w http.ResponseWriter, r *http.Request) {
+	warehouseID, err := readWarehouseID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	warehouse, err := app.Models.Warehouse.GetOne(warehouseID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, warehouse)
+}
+
+func (app *Config) CreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	warehouse := data.Warehouse{Active: true}
+	err := app.readJSON(w, r, &warehouse)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = warehouse.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	newWarehouse, err := app.Models.Warehouse.Insert(warehouse)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, newWarehouse)
+}
+
+// UpdateWarehouse changes the fields of a warehouse present in the body,
+// leaving the rest as they are, so that a body without active does not
+// take the warehouse out of allocation.
+func (app *Config) UpdateWarehouse(w http.ResponseWriter, r *http.Request) {
+	warehouseID, err := readWarehouseID(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	current, err := app.Models.Warehouse.GetOne(warehouseID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	warehouse := *current
+	err = app.readJSON(w, r, &warehouse)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	warehouse.ID = warehouseID
+
+	err = warehouse.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	updatedWarehouse, err := app.Models.Warehouse.Update(warehouse)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, updatedWarehouse)
+}
+
+// TransferStock moves stock of a product from one warehouse to another.
+func (app *Config) TransferStock(w http.ResponseWriter, r *http.Request) {
+	var transfer data.Transfer
+	err := app.readJSON(w, r, &transfer)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// A transfer that names no actor is put down to the caller
+	if claims, ok := auth.FromContext(r.Context()); ok && transfer.Actor == "" {
+		transfer.Actor = claims.Actor()
+	}
+
+	result, err := app.Models.Inventory.Transfer(transfer)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, result)
+}
+
+// AllocateStock picks the warehouses an order should ship from. Nothing is
+// held; reserve the stock, naming each shipment's warehouse, to hold it.
+func (app *Config) AllocateStock(w http.ResponseWriter, r *http.Request) {
+	var req data.AllocationRequest
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	allocation, err := app.Models.Warehouse.Allocate(req)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, allocation)
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrProductDeleted is returned when stock is reserved for an archived
+// product.
+var ErrProductDeleted = errors.New("product is archived")
+
+// checkCategoryLive returns ErrCategoryDeleted if the category with the
+// given id is archived. A category that does not exist is left to the
+// foreign key to reject.
+func checkCategoryLive(ctx context.Context, q queryer, id int) error {
+	var deleted bool
+	err := q.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM categories WHERE category_id = $1`, id).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return fmt.Errorf("%w: %d", ErrCategoryDeleted, id)
+	}
+	return nil
+}
+
+// Restore brings back an archived product. It returns ErrCategoryDeleted
+// if the product's category has been archived since, and sql.ErrNoRows if
+// the product does not exist. Restoring a product that is not archived does
+// nothing.
+func (m *ProductModel) Restore(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	var categoryID sql.NullInt32
+	err = tx.QueryRowContext(ctx,
+		`SELECT deleted_at IS NOT NULL, category_id FROM products WHERE product_id = $1 FOR UPDATE`,
+		id,
+	).Scan(&deleted, &categoryID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return nil
+	}
+
+	if categoryID.Valid {
+		err = checkCategoryLive(ctx, tx, int(categoryID.Int32))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE products SET deleted_at = NULL, updated_at = $1 WHERE product_id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventProductRestored, AggregateProduct, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Purge removes products archived before the given time, unless a stock
+// reservation still references them, and leaves a tombstone for the change
+// feed in their place. It returns the IDs of the products it removed.
+func (m *ProductModel) Purge(before time.Time) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		WITH purged AS (
+			DELETE FROM products p
+			WHERE p.deleted_at < $1
+			  AND NOT EXISTS (SELECT 1 FROM stock_reservation_items i WHERE i.product_id = p.product_id)
+			RETURNING p.product_id, p.deleted_at
+		)
+		INSERT INTO catalog_tombstones (kind, id, deleted_at)
+		SELECT $2::smallint, product_id, deleted_at FROM purged
+		RETURNING id
+	`
+
+	return returningIDs(ctx, m.DB, query, before, changeKindProduct)
+}
+
+// Restore brings back an archived category, along with the subcategories
+// archived with it. Products that were moved out of them stay where they
+// are. It returns ErrCategoryDeleted if its parent is archived, and
+// sql.ErrNoRows if the category does not exist. Restoring a category that is
+// not archived does nothing.
+func (m *CategoryModel) Restore(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return err
+	}
+
+	var deletedAt sql.NullTime
+	var parentID sql.NullInt32
+	err = tx.QueryRowContext(ctx,
+		`SELECT deleted_at, parent_category_id FROM categories WHERE category_id = $1`,
+		id,
+	).Scan(&deletedAt, &parentID)
+	if err != nil {
+		return err
+	}
+	if !deletedAt.Valid {
+		return nil
+	}
+
+	if parentID.Valid {
+		err = checkCategoryLive(ctx, tx, int(parentID.Int32))
+		if err != nil {
+			return err
+		}
+	}
+
+	subtree := fmt.Sprintf(categoryDescendantsQuery, "$1")
+
+	err = updateAndEnqueue(ctx, tx, EventCategoryRestored, AggregateCategory,
+		`UPDATE categories SET deleted_at = NULL, updated_at = $3 WHERE category_id IN (`+subtree+`) AND deleted_at = $2 RETURNING category_id`,
+		id, deletedAt.Time, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Purge removes categories archived before the given time that no product
+// or subcategory references any more, archived or not. A category is only
+// removed after its subcategories, so an archived tree is cleared from the
+// leaves up over successive runs. Each leaves a tombstone for the change
+// feed. It returns the IDs of the categories it removed.
+func (m *CategoryModel) Purge(before time.Time) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		WITH purged AS (
+			DELETE FROM categories c
+			WHERE c.deleted_at < $1
+			  AND NOT EXISTS (SELECT 1 FROM products p WHERE p.category_id = c.category_id)
+			  AND NOT EXISTS (SELECT 1 FROM categories s WHERE s.parent_category_id = c.category_id)
+			RETURNING c.category_id, c.deleted_at
+		)
+		INSERT INTO catalog_tombstones (kind, id, deleted_at)
+		SELECT $2::smallint, category_id, deleted_at FROM purged
+		RETURNING id
+	`
+
+	return returningIDs(ctx, m.DB, query, before, changeKindCategory)
+}
+
+// returningIDs runs a statement that returns the IDs of the rows it
+// changed or removed.
+func returningIDs(ctx context.Context, q queryer, query string, args ...any) ([]int, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,395 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgtype"
+)
+
+// Attribute types a category can declare.
+const (
+	AttributeNumber  = "number"
+	AttributeText    = "text"
+	AttributeEnum    = "enum"
+	AttributeBoolean = "boolean"
+)
+
+var attributeCodeRX = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// AttributeDefinition declares an attribute that products in a category, or
+// any of its subcategories, may carry.
+type AttributeDefinition struct {
+	Code       string   `json:"code"`
+	Label      string   `json:"label"`
+	Type       string   `json:"type"`
+	Unit       string   `json:"unit,omitempty"`
+	Values     []string `json:"values,omitempty"`
+	Required   bool     `json:"required"`
+	CategoryID int      `json:"categoryId"`
+}
+
+// AttributeFilter narrows a product listing by one attribute: to any of
+// Values, or for numbers to the range Min..Max.
+type AttributeFilter struct {
+	Code   string
+	Values []string
+	Min    *float64
+	Max    *float64
+}
+
+// Facet counts the products of a listing by the values of one attribute.
+// Number attributes report their range instead.
+type Facet struct {
+	Code   string         `json:"code"`
+	Values map[string]int `json:"values,omitempty"`
+	Min    *float64       `json:"min,omitempty"`
+	Max    *float64       `json:"max,omitempty"`
+}
+
+func (d AttributeDefinition) Validate() error {
+	if !attributeCodeRX.MatchString(d.Code) {
+		return fmt.Errorf("attribute code %q must be lower case letters, digits and underscores", d.Code)
+	}
+
+	switch d.Type {
+	case AttributeEnum:
+		if len(d.Values) == 0 {
+			return fmt.Errorf("enum attribute %q must list its values", d.Code)
+		}
+	case AttributeNumber, AttributeText, AttributeBoolean:
+		if len(d.Values) > 0 {
+			return fmt.Errorf("only enum attributes list values, %q is a %s", d.Code, d.Type)
+		}
+	default:
+		return fmt.Errorf("attribute %q has unknown type %q", d.Code, d.Type)
+	}
+
+	return nil
+}
+
+// check validates a product's value for the attribute.
+func (d AttributeDefinition) check(value any) error {
+	switch d.Type {
+	case AttributeNumber:
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("attribute %q must be a number", d.Code)
+		}
+	case AttributeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("attribute %q must be true or false", d.Code)
+		}
+	case AttributeText:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("attribute %q must be a string", d.Code)
+		}
+	case AttributeEnum:
+		s, ok := value.(string)
+		if !ok || !slices.Contains(d.Values, s) {
+			return fmt.Errorf("attribute %q must be one of %s", d.Code, strings.Join(d.Values, ", "))
+		}
+	}
+	return nil
+}
+
+// Attributes returns the attributes that apply to products in a category:
+// its own and those inherited from its ancestors.
+func (m *CategoryModel) Attributes(categoryID int) ([]AttributeDefinition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM categories WHERE category_id = $1)`, categoryID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	return categoryAttributes(ctx, m.DB, categoryID)
+}
+
+// SetAttributes replaces the attributes a category declares itself. An
+// attribute code may not repeat one the category inherits.
+func (m *CategoryModel) SetAttributes(categoryID int, defs []AttributeDefinition) ([]AttributeDefinition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	codes := map[string]bool{}
+	for _, d := range defs {
+		err := d.Validate()
+		if err != nil {
+			return nil, err
+		}
+		if codes[d.Code] {
+			return nil, fmt.Errorf("attribute %q is defined twice", d.Code)
+		}
+		codes[d.Code] = true
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Holding the tree lock stops an ancestor declaring the same codes meanwhile
+	err = lockCategoryTree(ctx, tx, categoryID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	inherited, err := categoryAttributes(ctx, tx, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range inherited {
+		if d.CategoryID != categoryID && codes[d.Code] {
+			return nil, fmt.Errorf("attribute %q is already declared by category %d", d.Code, d.CategoryID)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM category_attributes WHERE category_id = $1`, categoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, d := range defs {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO category_attributes (category_id, code, label, attribute_type, unit, allowed_values, required, position)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, categoryID, d.Code, d.Label, d.Type, d.Unit, textArray(d.Values), d.Required, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventCategoryUpdated, AggregateCategory, categoryID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := categoryAttributes(ctx, tx, categoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return attributes, nil
+}
+
+func categoryAttributes(ctx context.Context, q queryer, categoryID int) ([]AttributeDefinition, error) {
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT category_id, parent_category_id, 0 AS depth
+			FROM categories
+			WHERE category_id = $1
+			UNION ALL
+			SELECT c.category_id, c.parent_category_id, a.depth + 1
+			FROM categories c
+			JOIN ancestors a ON c.category_id = a.parent_category_id
+			WHERE a.depth < $2
+		)
+		SELECT ca.category_id, ca.code, ca.label, ca.attribute_type, COALESCE(ca.unit, ''), ca.allowed_values, ca.required
+		FROM category_attributes ca
+		JOIN ancestors a ON a.category_id = ca.category_id
+		ORDER BY a.depth DESC, ca.position
+	`
+
+	rows, err := q.QueryContext(ctx, query, categoryID, maxCategoryDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	defs := []AttributeDefinition{}
+
+	for rows.Next() {
+		var d AttributeDefinition
+		var values pgtype.TextArray
+
+		err := rows.Scan(&d.CategoryID, &d.Code, &d.Label, &d.Type, &d.Unit, &values, &d.Required)
+		if err != nil {
+			return nil, err
+		}
+
+		err = values.AssignTo(&d.Values)
+		if err != nil {
+			return nil, err
+		}
+
+		defs = append(defs, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return defs, nil
+}
+
+// validateAttributes checks a product's attribute values against the
+// attributes its category declares, and returns them encoded for storage.
+func validateAttributes(ctx context.Context, q queryer, categoryID *int, attributes map[string]any) ([]byte, error) {
+	if attributes == nil {
+		attributes = map[string]any{}
+	}
+
+	var defs []AttributeDefinition
+	if categoryID != nil {
+		var err error
+		defs, err = categoryAttributes(ctx, q, *categoryID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	known := map[string]AttributeDefinition{}
+	for _, d := range defs {
+		known[d.Code] = d
+
+		if _, ok := attributes[d.Code]; d.Required && !ok {
+			return nil, fmt.Errorf("attribute %q is required", d.Code)
+		}
+	}
+
+	for code, value := range attributes {
+		d, ok := known[code]
+		if !ok {
+			return nil, fmt.Errorf("attribute %q is not declared by the product's category", code)
+		}
+
+		err := d.check(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(attributes)
+}
+
+// Facets counts the products matching filter by each of their text, enum
+// and boolean attribute values, and reports the range of their number
+// attributes.
+func (m *ProductModel) Facets(filter ProductFilter) ([]Facet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var args queryArgs
+	where := filter.where(&args)
+
+	query := `
+		SELECT a.key, jsonb_typeof(a.value), a.value #>> '{}', count(*)
+		FROM products p
+		CROSS JOIN LATERAL jsonb_each(p.attributes) a
+		` + where + `
+		GROUP BY 1, 2, 3
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byCode := map[string]*Facet{}
+
+	for rows.Next() {
+		var code, kind, value string
+		var count int
+
+		err := rows.Scan(&code, &kind, &value, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		f, ok := byCode[code]
+		if !ok {
+			f = &Facet{Code: code}
+			byCode[code] = f
+		}
+
+		if kind == "number" {
+			var n float64
+			if json.Unmarshal([]byte(value), &n) != nil {
+				continue
+			}
+			if f.Min == nil || n < *f.Min {
+				f.Min = &n
+			}
+			if f.Max == nil || n > *f.Max {
+				f.Max = &n
+			}
+			continue
+		}
+
+		if f.Values == nil {
+			f.Values = map[string]int{}
+		}
+		f.Values[value] += count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	facets := []Facet{}
+	for _, f := range byCode {
+		facets = append(facets, *f)
+	}
+
+	sort.Slice(facets, func(i, j int) bool {
+		return facets[i].Code < facets[j].Code
+	})
+
+	return facets, nil
+}
+
+func (f AttributeFilter) Validate() error {
+	if !attributeCodeRX.MatchString(f.Code) {
+		return fmt.Errorf("unknown attribute %q", f.Code)
+	}
+	if len(f.Values) == 0 && f.Min == nil && f.Max == nil {
+		return fmt.Errorf("attribute %q filter needs a value or a range", f.Code)
+	}
+	if len(f.Values) > 0 && (f.Min != nil || f.Max != nil) {
+		return fmt.Errorf("attribute %q cannot be filtered by value and range at once", f.Code)
+	}
+	if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+		return errors.New("attribute min must not be greater than max")
+	}
+	return nil
+}
+
+// where returns the condition matching the filter.
+func (f AttributeFilter) where(args *queryArgs) string {
+	code := args.add(f.Code)
+
+	if len(f.Values) > 0 {
+		return "p.attributes ->> " + code + " = ANY(" + args.add(textArray(f.Values)) + ")"
+	}
+
+	number := "(CASE WHEN jsonb_typeof(p.attributes -> " + code + ") = 'number' THEN (p.attributes ->> " + code + ")::numeric END)"
+
+	var conds []string
+	if f.Min != nil {
+		conds = append(conds, number+" >= "+args.add(*f.Min))
+	}
+	if f.Max != nil {
+		conds = append(conds, number+" <= "+args.add(*f.Max))
+	}
+
+	return strings.Join(conds, " AND ")
+}
@@ -0,0 +1,420 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxCategoryDepth bounds the recursive category queries so that a cycle in
+// parent_category_id can never make them run forever.
+const maxCategoryDepth = 64
+
+// categoryDescendantsQuery selects the IDs of a category and all of its
+// descendants. The placeholder is substituted with the root category ID.
+const categoryDescendantsQuery = `
+	WITH RECURSIVE descendants AS (
+		SELECT category_id FROM categories WHERE category_id = %s
+		UNION
+		SELECT c.category_id
+		FROM categories c
+		JOIN descendants d ON c.parent_category_id = d.category_id
+	)
+	SELECT category_id FROM descendants`
+
+// Tree returns every category nested under its parent. Categories without a
+// parent, or whose parent no longer exists, are returned as roots. Archived
+// categories are left out.
+func (m *CategoryModel) Tree() ([]*Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT c.category_id, c.parent_category_id, c.category_title, c.image_url, c.created_at, c.updated_at,
+			       ARRAY[c.category_id] AS path
+			FROM categories c
+			WHERE c.deleted_at IS NULL
+			  AND (c.parent_category_id IS NULL
+			   OR NOT EXISTS (SELECT 1 FROM categories p WHERE p.category_id = c.parent_category_id))
+			UNION ALL
+			SELECT c.category_id, c.parent_category_id, c.category_title, c.image_url, c.created_at, c.updated_at,
+			       t.path || c.category_id
+			FROM categories c
+			JOIN tree t ON c.parent_category_id = t.category_id
+			WHERE c.deleted_at IS NULL AND NOT c.category_id = ANY(t.path) AND cardinality(t.path) < $1
+		)
+		SELECT category_id, parent_category_id, category_title, image_url, created_at, updated_at
+		FROM tree
+		ORDER BY path
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, maxCategoryDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roots := []*Category{}
+	byID := map[int]*Category{}
+
+	for rows.Next() {
+		var c Category
+		var parentID sql.NullInt32
+
+		err := rows.Scan(
+			&c.ID,
+			&parentID,
+			&c.Title,
+			&c.ImageURL,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		byID[c.ID] = &c
+
+		// Rows come back depth first, so a parent is always seen before its children
+		if parent, ok := byID[int(parentID.Int32)]; parentID.Valid && ok {
+			parent.Children = append(parent.Children, &c)
+		} else {
+			roots = append(roots, &c)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roots, nil
+}
+
+// Breadcrumbs returns the path from the root category down to the category
+// with the given id, inclusive. It returns sql.ErrNoRows if the category does
+// not exist or is archived.
+func (m *CategoryModel) Breadcrumbs(id int) ([]*Category, error) {
+	return m.breadcrumbs(id, false)
+}
+
+// breadcrumbs is Breadcrumbs, also finding an archived category with
+// includeDeleted.
+func (m *CategoryModel) breadcrumbs(id int, includeDeleted bool) ([]*Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT category_id, parent_category_id, category_title, image_url, deleted_at, created_at, updated_at, version, 0 AS depth
+			FROM categories
+			WHERE category_id = $1 AND (deleted_at IS NULL OR $3)
+			UNION ALL
+			SELECT c.category_id, c.parent_category_id, c.category_title, c.image_url, c.deleted_at, c.created_at, c.updated_at, c.version, a.depth + 1
+			FROM categories c
+			JOIN ancestors a ON c.category_id = a.parent_category_id
+			WHERE a.depth < $2
+		)
+		SELECT category_id, category_title, image_url, deleted_at, created_at, updated_at, version
+		FROM ancestors
+		ORDER BY depth DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, id, maxCategoryDepth, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var path []*Category
+
+	for rows.Next() {
+		var c Category
+
+		err := rows.Scan(
+			&c.ID,
+			&c.Title,
+			&c.ImageURL,
+			&c.DeletedAt,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&c.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		path = append(path, &c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(path) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return path, nil
+}
+
+var (
+	// ErrCategoryCycle is returned when a category would become its own
+	// ancestor.
+	ErrCategoryCycle = errors.New("category cannot be moved under itself or one of its descendants")
+
+	// ErrCategoryInUse is returned when a category is deleted with
+	// DeleteRestrict while products or subcategories still reference it.
+	ErrCategoryInUse = errors.New("category is still in use")
+
+	// ErrCategoryDeleted is returned when a product or category would be
+	// placed in an archived category.
+	ErrCategoryDeleted = errors.New("category is archived")
+)
+
+// DeletePolicy decides what happens to the products and subcategories of a
+// category being deleted.
+type DeletePolicy string
+
+const (
+	// DeleteRestrict refuses to delete a category that has products or
+	// subcategories.
+	DeleteRestrict DeletePolicy = "restrict"
+	// DeleteReassignToParent moves the category's products and
+	// subcategories up to its parent.
+	DeleteReassignToParent DeletePolicy = "reassign-to-parent"
+	// DeleteCascadeUncategorize deletes the category with all of its
+	// descendants and leaves their products without a category.
+	DeleteCascadeUncategorize DeletePolicy = "cascade-uncategorize"
+)
+
+func ParseDeletePolicy(s string) (DeletePolicy, error) {
+	switch p := DeletePolicy(s); p {
+	case DeleteRestrict, DeleteReassignToParent, DeleteCascadeUncategorize:
+		return p, nil
+	case "":
+		return DeleteRestrict, nil
+	default:
+		return "", fmt.Errorf("unknown delete policy %q", s)
+	}
+}
+
+// Move re-parents the category with the given id. A nil parentID makes it a
+// root category. It returns ErrCategoryCycle if parentID is the category
+// itself or one of its descendants.
+func (m *CategoryModel) Move(id int, parentID *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCategoryTree(ctx, tx, id, parentID)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE categories SET parent_category_id = $1, updated_at = $2 WHERE category_id = $3`
+
+	_, err = tx.ExecContext(ctx, query, parentID, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventCategoryUpdated, AggregateCategory, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockCategoryTree serializes changes to the category hierarchy for the rest
+// of tx, then checks that category id exists and can be placed under
+// parentID without creating a cycle.
+func lockCategoryTree(ctx context.Context, tx *sql.Tx, id int, parentID *int) error {
+	_, err := tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM categories WHERE category_id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	if parentID == nil {
+		return nil
+	}
+
+	err = checkCategoryLive(ctx, tx, *parentID)
+	if err != nil {
+		return err
+	}
+
+	// Walk up from the new parent; reaching id means id is one of its ancestors
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT category_id, parent_category_id FROM categories WHERE category_id = $1
+			UNION
+			SELECT c.category_id, c.parent_category_id
+			FROM categories c
+			JOIN ancestors a ON c.category_id = a.parent_category_id
+		)
+		SELECT count(*), coalesce(bool_or(category_id = $2), false) FROM ancestors
+	`
+
+	var found int
+	var cycle bool
+	err = tx.QueryRowContext(ctx, query, *parentID, id).Scan(&found, &cycle)
+	if err != nil {
+		return err
+	}
+
+	if found == 0 {
+		return fmt.Errorf("parent category %d does not exist", *parentID)
+	}
+	if cycle {
+		return ErrCategoryCycle
+	}
+
+	return nil
+}
+
+// Delete archives the category with the given id, handling its products and
+// subcategories according to policy. Archived categories are hidden until
+// they are restored, or removed by Purge.
+func (m *CategoryModel) Delete(id int, policy DeletePolicy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCategoryTree(ctx, tx, id, nil)
+	if err != nil {
+		return err
+	}
+
+	switch policy {
+	case DeleteRestrict:
+		var products, children int
+		query := `
+			SELECT (SELECT count(*) FROM products WHERE category_id = $1 AND deleted_at IS NULL),
+			       (SELECT count(*) FROM categories WHERE parent_category_id = $1 AND deleted_at IS NULL)
+		`
+		err = tx.QueryRowContext(ctx, query, id).Scan(&products, &children)
+		if err != nil {
+			return err
+		}
+		if products > 0 || children > 0 {
+			return fmt.Errorf("%w: %d products and %d subcategories reference it", ErrCategoryInUse, products, children)
+		}
+
+	case DeleteReassignToParent:
+		var parentID sql.NullInt32
+		err = tx.QueryRowContext(ctx, `SELECT parent_category_id FROM categories WHERE category_id = $1`, id).Scan(&parentID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		err = updateAndEnqueue(ctx, tx, EventProductUpdated, AggregateProduct,
+			`UPDATE products SET category_id = $1, updated_at = $2 WHERE category_id = $3 RETURNING product_id`,
+			parentID, now, id,
+		)
+		if err != nil {
+			return err
+		}
+		err = updateAndEnqueue(ctx, tx, EventCategoryUpdated, AggregateCategory,
+			`UPDATE categories SET parent_category_id = $1, updated_at = $2 WHERE parent_category_id = $3 RETURNING category_id`,
+			parentID, now, id,
+		)
+		if err != nil {
+			return err
+		}
+
+	case DeleteCascadeUncategorize:
+		subtree := fmt.Sprintf(categoryDescendantsQuery, "$1")
+		now := time.Now()
+
+		err = updateAndEnqueue(ctx, tx, EventProductUpdated, AggregateProduct,
+			`UPDATE products SET category_id = NULL, updated_at = $2 WHERE category_id IN (`+subtree+`) RETURNING product_id`,
+			id, now,
+		)
+		if err != nil {
+			return err
+		}
+		// Archived at the same instant, so that restoring the category
+		// brings back the subcategories archived with it
+		err = updateAndEnqueue(ctx, tx, EventCategoryDeleted, AggregateCategory,
+			`UPDATE categories SET deleted_at = $2 WHERE category_id IN (`+subtree+`) AND deleted_at IS NULL RETURNING category_id`,
+			id, now,
+		)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+
+	default:
+		return fmt.Errorf("unknown delete policy %q", policy)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE categories SET deleted_at = $1 WHERE category_id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventCategoryDeleted, AggregateCategory, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updateAndEnqueue runs a statement that returns the IDs of the rows it
+// changed, and enqueues an event without data for each of them.
+func updateAndEnqueue(ctx context.Context, tx *sql.Tx, eventType, aggregateType, query string, args ...any) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = enqueueCatalogEvent(ctx, tx, eventType, aggregateType, id, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// MaxChangePageSize is the most changes a single read of the feed returns.
+const MaxChangePageSize = 500
+
+// Change kinds, in the order changes made by the same transaction are
+// listed: categories before the products in them.
+const (
+	changeKindCategory = 1
+	changeKindProduct  = 2
+)
+
+// Change is a product or category that changed after a cursor. Deleted
+// changes are tombstones for rows archived, purged or, for products, no
+// longer published, and carry only the time of the change.
+type Change struct {
+	Type      string    `json:"type"`
+	ID        int       `json:"id"`
+	Deleted   bool      `json:"deleted"`
+	ChangedAt time.Time `json:"changedAt"`
+	Product   *Product  `json:"product,omitempty"`
+	Category  *Category `json:"category,omitempty"`
+}
+
+// ChangePage is one read of the change feed. Cursor is where the next read
+// should start from, and HasMore is set when there are changes after this
+// page that are already visible.
+type ChangePage struct {
+	Changes []*Change `json:"changes"`
+	Cursor  string    `json:"cursor"`
+	HasMore bool      `json:"hasMore"`
+}
+
+// ChangeCursor is a position in the change feed. Every insert or update of
+// a product or category stamps the row with the id of its transaction, and
+// changes are ordered by that id, then kind, then row id. A cursor only
+// moves past transaction ids below the oldest one still running, so a
+// transaction that commits late cannot land behind a cursor already handed
+// out.
+type ChangeCursor struct {
+	TxID int64
+	Kind int
+	ID   int
+}
+
+// String encodes the cursor as an opaque token.
+func (c ChangeCursor) String() string {
+	s := fmt.Sprintf("%d.%d.%d", c.TxID, c.Kind, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// ParseChangeCursor decodes a token made by ChangeCursor.String. An empty
+// token is the start of the feed.
+func ParseChangeCursor(s string) (ChangeCursor, error) {
+	var c ChangeCursor
+	if s == "" {
+		return c, nil
+	}
+
+	invalid := errors.New("since is not a valid cursor")
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, invalid
+	}
+
+	parts := strings.Split(string(b), ".")
+	if len(parts) != 3 {
+		return c, invalid
+	}
+
+	c.TxID, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || c.TxID < 0 {
+		return c, invalid
+	}
+	c.Kind, err = strconv.Atoi(parts[1])
+	if err != nil || c.Kind < 0 {
+		return c, invalid
+	}
+	c.ID, err = strconv.Atoi(parts[2])
+	if err != nil || c.ID < 0 {
+		return c, invalid
+	}
+
+	return c, nil
+}
+
+// ChangeModel reads the catalog change feed.
+type ChangeModel struct {
+	DB *sql.DB
+}
+
+// Since returns up to limit products and categories changed after cursor,
+// oldest first, with the cursor to read on from. Archived and purged rows
+// and products that are not published come back as tombstones, except when
+// reading from the start of the feed, where only live, published rows are
+// listed.
+func (m *ChangeModel) Since(cursor ChangeCursor, limit int) (*ChangePage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// One snapshot for the keys and the rows they point to, so a row that
+	// changes again mid-read is not reported with a newer state than its
+	// place in the feed
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Transactions from before the oldest one still running have all
+	// finished, so their changes can be read without any being missed
+	var horizon int64
+	err = tx.QueryRowContext(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint`).Scan(&horizon)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT change_txid, kind, id, deleted, purged_at
+		FROM (
+			SELECT change_txid, 1 AS kind, category_id AS id, deleted_at IS NOT NULL AS deleted, NULL::timestamp AS purged_at
+			FROM categories
+			UNION ALL
+			SELECT change_txid, 2, product_id, deleted_at IS NOT NULL OR status <> '` + ProductPublished + `', NULL
+			FROM products
+			UNION ALL
+			SELECT change_txid, kind, id, true, deleted_at
+			FROM catalog_tombstones
+		) changes
+		WHERE change_txid < $1
+		  AND (change_txid, kind, id) > ($2, $3, $4)
+		  AND (NOT deleted OR $2 > 0)
+		ORDER BY change_txid, kind, id
+		LIMIT $5
+	`
+
+	rows, err := tx.QueryContext(ctx, query, horizon, cursor.TxID, cursor.Kind, cursor.ID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type changeKey struct {
+		ChangeCursor
+		deleted  bool
+		purgedAt sql.NullTime
+	}
+
+	var keys []changeKey
+
+	for rows.Next() {
+		var k changeKey
+		err := rows.Scan(&k.TxID, &k.Kind, &k.ID, &k.deleted, &k.purgedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &ChangePage{Changes: []*Change{}}
+
+	if len(keys) > limit {
+		keys = keys[:limit]
+		page.HasMore = true
+		page.Cursor = keys[limit-1].ChangeCursor.String()
+	} else if horizon > cursor.TxID {
+		page.Cursor = ChangeCursor{TxID: horizon}.String()
+	} else {
+		page.Cursor = cursor.String()
+	}
+
+	var productIDs, categoryIDs []int32
+	for _, k := range keys {
+		if k.Kind == changeKindProduct {
+			productIDs = append(productIDs, int32(k.ID))
+		} else {
+			categoryIDs = append(categoryIDs, int32(k.ID))
+		}
+	}
+
+	products, err := changedProducts(ctx, tx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := changedCategories(ctx, tx, categoryIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		c := &Change{ID: k.ID, Deleted: k.deleted}
+
+		if k.purgedAt.Valid {
+			c.Type = AggregateCategory
+			if k.Kind == changeKindProduct {
+				c.Type = AggregateProduct
+			}
+			c.ChangedAt = k.purgedAt.Time
+		} else if k.Kind == changeKindProduct {
+			p, ok := products[k.ID]
+			if !ok {
+				continue
+			}
+			c.Type = AggregateProduct
+			c.ChangedAt = p.UpdatedAt
+			if p.DeletedAt != nil {
+				c.ChangedAt = *p.DeletedAt
+			}
+			if !k.deleted {
+				c.Product = p
+			}
+		} else {
+			cat, ok := categories[k.ID]
+			if !ok {
+				continue
+			}
+			c.Type = AggregateCategory
+			c.ChangedAt = cat.UpdatedAt
+			if cat.DeletedAt != nil {
+				c.ChangedAt = *cat.DeletedAt
+			} else {
+				c.Category = cat
+			}
+		}
+
+		page.Changes = append(page.Changes, c)
+	}
+
+	return page, tx.Commit()
+}
+
+// changedProducts returns the products with the given ids, archived or not,
+// by id.
+func changedProducts(ctx context.Context, q queryer, ids []int32) (map[int]*Product, error) {
+	products := map[int]*Product{}
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	var idArray pgtype.Int4Array
+	err := idArray.Set(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + productColumns + `
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.category_id
+		WHERE p.product_id = ANY($1)
+	`
+
+	rows, err := q.QueryContext(ctx, query, &idArray)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products[p.ID] = p
+	}
+
+	return products, rows.Err()
+}
+
+// changedCategories returns the categories with the given ids, archived or
+// not, with their parents, by id.
+func changedCategories(ctx context.Context, q queryer, ids []int32) (map[int]*Category, error) {
+	categories := map[int]*Category{}
+	if len(ids) == 0 {
+		return categories, nil
+	}
+
+	var idArray pgtype.Int4Array
+	err := idArray.Set(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT c.category_id, c.category_title, c.image_url, c.deleted_at, c.created_at, c.updated_at,
+		       p.category_id, p.category_title, p.image_url
+		FROM categories c
+		LEFT JOIN categories p ON c.parent_category_id = p.category_id
+		WHERE c.category_id = ANY($1)
+	`
+
+	rows, err := q.QueryContext(ctx, query, &idArray)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Category
+		var p Category
+		var pID sql.NullInt32
+		var pTitle sql.NullString
+		var pImage sql.NullString
+
+		err := rows.Scan(
+			&c.ID,
+			&c.Title,
+			&c.ImageURL,
+			&c.DeletedAt,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&pID,
+			&pTitle,
+			&pImage,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if pID.Valid {
+			p.ID = int(pID.Int32)
+			p.Title = pTitle.String
+			p.ImageURL = pImage.String
+			c.ParentCategory = &p
+		}
+
+		categories[c.ID] = &c
+	}
+
+	return categories, rows.Err()
+}
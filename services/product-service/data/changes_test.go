@@ -0,0 +1,42 @@
+package data
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseChangeCursor(t *testing.T) {
+	token := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name    string
+		s       string
+		want    ChangeCursor
+		wantErr bool
+	}{
+		{"start of feed", "", ChangeCursor{}, false},
+		{"round trip", ChangeCursor{TxID: 9001, Kind: changeKindProduct, ID: 42}.String(), ChangeCursor{9001, changeKindProduct, 42}, false},
+		{"zero", token("0.0.0"), ChangeCursor{}, false},
+		{"not base64", "not a cursor!", ChangeCursor{}, true},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("1.1.1")), ChangeCursor{}, true},
+		{"too few parts", token("1.2"), ChangeCursor{}, true},
+		{"too many parts", token("1.2.3.4"), ChangeCursor{}, true},
+		{"negative txid", token("-1.2.3"), ChangeCursor{}, true},
+		{"negative kind", token("1.-2.3"), ChangeCursor{}, true},
+		{"negative id", token("1.2.-3"), ChangeCursor{}, true},
+		{"not a number", token("1.two.3"), ChangeCursor{}, true},
+		{"txid overflow", token("9223372036854775808.1.1"), ChangeCursor{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChangeCursor(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseChangeCursor(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseChangeCursor(%q) = %+v, want %+v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
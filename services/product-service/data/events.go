@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Queues that events are published to.
+const (
+	// InventoryQueue is the queue the notification service consumes
+	// inventory events from.
+	InventoryQueue = "inventory"
+	// CatalogQueue carries product and category change events.
+	CatalogQueue = "catalog"
+)
+
+const EventLowStock = "low_stock"
+
+// Catalog event types.
+const (
+	EventProductCreated     = "product.created"
+	EventProductUpdated     = "product.updated"
+	EventProductDeleted     = "product.deleted"
+	EventProductRestored    = "product.restored"
+	EventProductPublished   = "product.published"
+	EventProductUnpublished = "product.unpublished"
+	EventCategoryCreated    = "category.created"
+	EventCategoryUpdated    = "category.updated"
+	EventCategoryDeleted    = "category.deleted"
+	EventCategoryRestored   = "category.restored"
+)
+
+// Aggregate types that events are ordered by.
+const (
+	AggregateProduct  = "product"
+	AggregateCategory = "category"
+)
+
+// InventoryEvent matches the event the notification service expects on
+// InventoryQueue.
+type InventoryEvent struct {
+	EventType   string    `json:"event_type"`
+	ProductID   string    `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	Quantity    int       `json:"quantity"`
+	Threshold   int       `json:"threshold"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// CatalogEvent is published on CatalogQueue whenever a product or category
+// is created, updated or deleted. Data holds the saved product or category;
+// it is omitted for deletes and for changes made in bulk, such as moving a
+// deleted category's products, where consumers should fetch the aggregate.
+type CatalogEvent struct {
+	EventType     string    `json:"event_type"`
+	AggregateType string    `json:"aggregate_type"`
+	AggregateID   string    `json:"aggregate_id"`
+	Data          any       `json:"data,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// enqueueCatalogEvent writes a catalog event to the outbox within tx.
+func enqueueCatalogEvent(ctx context.Context, tx *sql.Tx, eventType, aggregateType string, aggregateID int, payload any) error {
+	id := strconv.Itoa(aggregateID)
+
+	return enqueue(ctx, tx, aggregateType, id, CatalogQueue, CatalogEvent{
+		EventType:     eventType,
+		AggregateType: aggregateType,
+		AggregateID:   id,
+		Data:          payload,
+		Timestamp:     time.Now(),
+	})
+}
+
+// checkLowStock re-evaluates whether a product is at or below its low stock
+// threshold, and enqueues a low_stock event only when the product has just
+// crossed it. The alert re-arms once quantity rises above the threshold.
+func checkLowStock(ctx context.Context, tx *sql.Tx, productID int) error {
+	query := `
+		UPDATE products
+		SET low_stock_alerted = (COALESCE(quantity, 0) <= low_stock_threshold)
+		WHERE product_id = $1
+		  AND low_stock_threshold IS NOT NULL
+		  AND low_stock_alerted IS DISTINCT FROM (COALESCE(quantity, 0) <= low_stock_threshold)
+		RETURNING low_stock_alerted, COALESCE(product_title, ''), COALESCE(quantity, 0), low_stock_threshold
+	`
+
+	var alerted bool
+	e := InventoryEvent{
+		EventType: EventLowStock,
+		ProductID: strconv.Itoa(productID),
+		Timestamp: time.Now(),
+	}
+
+	err := tx.QueryRowContext(ctx, query, productID).Scan(&alerted, &e.ProductName, &e.Quantity, &e.Threshold)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !alerted {
+		return nil
+	}
+
+	return enqueue(ctx, tx, AggregateProduct, e.ProductID, InventoryQueue, e)
+}
@@ -0,0 +1,380 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgtype"
+)
+
+// Formats an export can be written in.
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatNDJSON = "ndjson"
+	ExportFormatGMC    = "gmc-xml"
+)
+
+// exportFetchSize is how many rows are fetched from the export cursor at a
+// time.
+const exportFetchSize = 500
+
+// gmcNamespace is the namespace of the Google Merchant Center feed elements.
+const gmcNamespace = "http://base.google.com/ns/1.0"
+
+// ExportOptions selects the products an export writes, and how.
+type ExportOptions struct {
+	Format string
+	Filter ProductFilter
+	Sort   []SortField
+	// StorefrontURL is the base of the product page links in a Google
+	// Merchant feed, which are left out without it.
+	StorefrontURL string
+}
+
+func (o ExportOptions) Validate() error {
+	switch o.Format {
+	case ExportFormatCSV, ExportFormatNDJSON, ExportFormatGMC:
+	default:
+		return fmt.Errorf("unknown export format %q", o.Format)
+	}
+	return o.Filter.Validate()
+}
+
+// exportWriter writes exported products in one format.
+type exportWriter interface {
+	write(p *Product, breadcrumbs []string) error
+	close() error
+}
+
+// Export writes the products matching opts.Filter to w. Products are read
+// through a database cursor a batch at a time, so the whole catalog is never
+// held in memory, and from a single snapshot, so a product changed during
+// the export is written once as it was when the export started. It returns
+// how many products it wrote.
+func (m *ProductModel) Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	err := opts.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var args queryArgs
+	depth := args.add(maxCategoryDepth)
+	where := opts.Filter.where(&args)
+
+	// Each category's path of titles from its root, for the breadcrumbs
+	query := `
+		DECLARE product_export NO SCROLL CURSOR FOR
+		WITH RECURSIVE category_paths AS (
+			SELECT c.category_id, ARRAY[c.category_id] AS ids, ARRAY[c.category_title::text] AS titles
+			FROM categories c
+			WHERE c.parent_category_id IS NULL
+			   OR NOT EXISTS (SELECT 1 FROM categories p WHERE p.category_id = c.parent_category_id)
+			UNION ALL
+			SELECT c.category_id, cp.ids || c.category_id, cp.titles || c.category_title::text
+			FROM categories c
+			JOIN category_paths cp ON c.parent_category_id = cp.category_id
+			WHERE NOT c.category_id = ANY(cp.ids) AND cardinality(cp.ids) < ` + depth + `
+		)
+		SELECT ` + productColumns + `, cp.titles
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.category_id
+		LEFT JOIN category_paths cp ON p.category_id = cp.category_id
+		` + where + `
+		` + ListOptions{Sort: opts.Sort}.orderBy()
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := bufio.NewWriter(w)
+	out := newExportWriter(buf, opts)
+
+	count := 0
+	for {
+		n, err := fetchExport(ctx, tx, out)
+		if err != nil {
+			return count, err
+		}
+		count += n
+
+		// Send each batch on rather than holding it until the buffer fills
+		err = buf.Flush()
+		if err != nil {
+			return count, err
+		}
+
+		if n < exportFetchSize {
+			break
+		}
+	}
+
+	err = out.close()
+	if err != nil {
+		return count, err
+	}
+
+	return count, buf.Flush()
+}
+
+// fetchExport writes the next batch of products from the export cursor and
+// returns how many it wrote.
+func fetchExport(ctx context.Context, tx *sql.Tx, out exportWriter) (int, error) {
+	rows, err := tx.QueryContext(ctx, `FETCH `+strconv.Itoa(exportFetchSize)+` FROM product_export`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var titles pgtype.TextArray
+
+		p, err := scanProduct(rows, &titles)
+		if err != nil {
+			return n, err
+		}
+
+		var breadcrumbs []string
+		err = titles.AssignTo(&breadcrumbs)
+		if err != nil {
+			return n, err
+		}
+
+		err = out.write(p, breadcrumbs)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, rows.Err()
+}
+
+func newExportWriter(w io.Writer, opts ExportOptions) exportWriter {
+	switch opts.Format {
+	case ExportFormatNDJSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return &ndjsonExporter{enc: enc}
+	case ExportFormatGMC:
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		return &gmcExporter{enc: enc, storefrontURL: strings.TrimSuffix(opts.StorefrontURL, "/")}
+	default:
+		return &csvExporter{w: csv.NewWriter(w)}
+	}
+}
+
+// exportRecord is a product in the columns of an import, so that an export
+// can be imported again.
+func exportRecord(p *Product, breadcrumbs []string) []string {
+	threshold := ""
+	if p.LowStockThreshold != nil {
+		threshold = strconv.Itoa(*p.LowStockThreshold)
+	}
+
+	return []string{
+		p.SKU,
+		p.Title,
+		p.PriceUnit.Decimal(),
+		p.PriceUnit.Currency,
+		strconv.Itoa(p.Quantity),
+		strings.Join(breadcrumbs, "/"),
+		p.ImageURL,
+		threshold,
+	}
+}
+
+type csvExporter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (e *csvExporter) write(p *Product, breadcrumbs []string) error {
+	err := e.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	err = e.w.Write(exportRecord(p, breadcrumbs))
+	if err != nil {
+		return err
+	}
+
+	// The csv writer has a buffer of its own; empty it into the export's so
+	// that each batch is sent on whole
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExporter) writeHeader() error {
+	if e.wroteHeader {
+		return nil
+	}
+	e.wroteHeader = true
+	return e.w.Write(importColumns)
+}
+
+func (e *csvExporter) close() error {
+	// An empty export still has its header
+	err := e.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExporter) write(p *Product, breadcrumbs []string) error {
+	obj := map[string]any{}
+	for i, v := range exportRecord(p, breadcrumbs) {
+		if v != "" {
+			obj[importColumns[i]] = v
+		}
+	}
+	obj["quantity"] = p.Quantity
+	if p.LowStockThreshold != nil {
+		obj["lowStockThreshold"] = *p.LowStockThreshold
+	}
+
+	// Encode ends every value with a newline
+	return e.enc.Encode(obj)
+}
+
+func (e *ndjsonExporter) close() error {
+	return nil
+}
+
+// gmcItem is a product in a Google Merchant Center feed.
+type gmcItem struct {
+	XMLName      xml.Name `xml:"item"`
+	ID           string   `xml:"g:id"`
+	Title        string   `xml:"g:title"`
+	Description  string   `xml:"g:description"`
+	Link         string   `xml:"g:link,omitempty"`
+	ImageLink    string   `xml:"g:image_link,omitempty"`
+	Price        string   `xml:"g:price"`
+	Availability string   `xml:"g:availability"`
+	ProductType  string   `xml:"g:product_type,omitempty"`
+}
+
+// gmcExporter writes an RSS 2.0 feed in the Google Merchant Center product
+// data format.
+type gmcExporter struct {
+	enc           *xml.Encoder
+	storefrontURL string
+	started       bool
+}
+
+func (e *gmcExporter) start() error {
+	e.started = true
+
+	err := e.enc.EncodeToken(xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="UTF-8"`)})
+	if err != nil {
+		return err
+	}
+
+	err = e.enc.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: "rss"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "version"}, Value: "2.0"},
+			{Name: xml.Name{Local: "xmlns:g"}, Value: gmcNamespace},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = e.enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: "channel"}})
+	if err != nil {
+		return err
+	}
+
+	err = e.enc.EncodeElement("Products", xml.StartElement{Name: xml.Name{Local: "title"}})
+	if err != nil {
+		return err
+	}
+
+	if e.storefrontURL != "" {
+		err = e.enc.EncodeElement(e.storefrontURL, xml.StartElement{Name: xml.Name{Local: "link"}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *gmcExporter) write(p *Product, breadcrumbs []string) error {
+	if !e.started {
+		err := e.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	item := gmcItem{
+		ID:    p.SKU,
+		Title: p.Title,
+		// Products have no description of their own, and the feed requires one
+		Description:  p.Title,
+		ImageLink:    p.ImageURL,
+		Price:        p.PriceUnit.String(),
+		Availability: "out_of_stock",
+		ProductType:  strings.Join(breadcrumbs, " > "),
+	}
+	if p.Available > 0 {
+		item.Availability = "in_stock"
+	}
+	if e.storefrontURL != "" {
+		item.Link = e.storefrontURL + "/products/" + strconv.Itoa(p.ID)
+	}
+
+	err := e.enc.Encode(item)
+	if err != nil {
+		return err
+	}
+
+	return e.enc.Flush()
+}
+
+func (e *gmcExporter) close() error {
+	if !e.started {
+		err := e.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := e.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "channel"}})
+	if err != nil {
+		return err
+	}
+
+	err = e.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "rss"}})
+	if err != nil {
+		return err
+	}
+
+	return e.enc.Flush()
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// productSortColumns maps the sort keys accepted by the API to the SQL
+// expression they order by. Prices are amounts of each product's own
+// currency, so sorting by price groups products by currency first and only
+// orders prices within each currency.
+var productSortColumns = map[string]string{
+	"product_id":    "p.product_id",
+	"product_title": "p.product_title",
+	"sku":           "p.sku",
+	"price_unit":    "p.currency, p.price_unit",
+	"quantity":      "p.quantity",
+	"created_at":    "p.created_at",
+	"updated_at":    "p.updated_at",
+}
+
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+type ListOptions struct {
+	Page int
+	Size int
+	Sort []SortField
+}
+
+type ProductFilter struct {
+	CategoryID *int
+	// IncludeDescendants widens CategoryID to match products in any of its
+	// subcategories as well.
+	IncludeDescendants bool
+	// MinPrice and MaxPrice bound the product's own price, and only match
+	// products priced in their currency.
+	MinPrice *Money
+	MaxPrice *Money
+	InStock  *bool
+	// Attributes must all match.
+	Attributes []AttributeFilter
+	// IncludeDeleted also matches archived products.
+	IncludeDeleted bool
+	// IncludeUnpublished also matches products that are not published.
+	IncludeUnpublished bool
+}
+
+// ParseProductSort parses a comma separated list of sort keys such as
+// "price_unit,-created_at". A leading "-" sorts that key descending.
+func ParseProductSort(s string) ([]SortField, error) {
+	var fields []SortField
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		field := SortField{Column: part}
+		if strings.HasPrefix(part, "-") {
+			field.Column = part[1:]
+			field.Desc = true
+		}
+
+		if _, ok := productSortColumns[field.Column]; !ok {
+			return nil, fmt.Errorf("unknown sort field %q", field.Column)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+func (o ListOptions) Validate() error {
+	if o.Page < 1 {
+		return errors.New("page must be greater than zero")
+	}
+	if o.Size < 1 || o.Size > MaxPageSize {
+		return fmt.Errorf("size must be between 1 and %d", MaxPageSize)
+	}
+	return nil
+}
+
+func (o ListOptions) offset() int {
+	return (o.Page - 1) * o.Size
+}
+
+func (o ListOptions) orderBy() string {
+	var terms []string
+	for _, f := range o.Sort {
+		term := productSortColumns[f.Column]
+		if f.Desc {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+	}
+
+	// Always finish with the primary key so pages are stable
+	terms = append(terms, "p.product_id")
+
+	return "ORDER BY " + strings.Join(terms, ", ")
+}
+
+func (f ProductFilter) Validate() error {
+	if f.IncludeDescendants && f.CategoryID == nil {
+		return errors.New("includeDescendants requires categoryId")
+	}
+	if f.MinPrice != nil && f.MinPrice.IsNegative() {
+		return errors.New("minPrice must not be negative")
+	}
+	if f.MaxPrice != nil && f.MaxPrice.IsNegative() {
+		return errors.New("maxPrice must not be negative")
+	}
+	if f.MinPrice != nil && f.MaxPrice != nil && f.MinPrice.Currency != f.MaxPrice.Currency {
+		return errors.New("minPrice and maxPrice must be in the same currency")
+	}
+	if f.MinPrice != nil && f.MaxPrice != nil && f.MinPrice.Amount > f.MaxPrice.Amount {
+		return errors.New("minPrice must not be greater than maxPrice")
+	}
+	for _, a := range f.Attributes {
+		err := a.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// where builds the WHERE clause for the filter, appending its
+// placeholders' values to args.
+func (f ProductFilter) where(args *queryArgs) string {
+	var conds []string
+
+	if !f.IncludeDeleted {
+		conds = append(conds, "p.deleted_at IS NULL")
+	}
+	if !f.IncludeUnpublished {
+		conds = append(conds, "p.status = "+args.add(ProductPublished))
+	}
+	if f.CategoryID != nil {
+		if f.IncludeDescendants {
+			conds = append(conds, "p.category_id IN ("+fmt.Sprintf(categoryDescendantsQuery, args.add(*f.CategoryID))+")")
+		} else {
+			conds = append(conds, "p.category_id = "+args.add(*f.CategoryID))
+		}
+	}
+	if f.MinPrice != nil {
+		conds = append(conds, "p.currency = "+args.add(f.MinPrice.Currency), "p.price_unit >= "+args.add(*f.MinPrice))
+	}
+	if f.MaxPrice != nil {
+		conds = append(conds, "p.currency = "+args.add(f.MaxPrice.Currency), "p.price_unit <= "+args.add(*f.MaxPrice))
+	}
+	if f.InStock != nil {
+		if *f.InStock {
+			conds = append(conds, "COALESCE(p.quantity, 0) - p.reserved > 0")
+		} else {
+			conds = append(conds, "COALESCE(p.quantity, 0) - p.reserved <= 0")
+		}
+	}
+	for _, a := range f.Attributes {
+		conds = append(conds, a.where(args))
+	}
+
+	if len(conds) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(conds, " AND ")
+}
+
+// queryArgs collects positional arguments while a query is being built.
+type queryArgs []any
+
+func (a *queryArgs) add(v any) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestProductFilterPrices(t *testing.T) {
+	usd := &Money{1000, "USD"}
+	eur := &Money{2000, "EUR"}
+
+	tests := []struct {
+		name     string
+		filter   ProductFilter
+		want     string
+		wantArgs string
+		wantErr  bool
+	}{
+		{
+			name:     "min",
+			filter:   ProductFilter{MinPrice: usd, IncludeDeleted: true, IncludeUnpublished: true},
+			want:     "WHERE p.currency = $1 AND p.price_unit >= $2",
+			wantArgs: "[USD 10.00 USD]",
+		},
+		{
+			name:     "min and max",
+			filter:   ProductFilter{MinPrice: &Money{500, "EUR"}, MaxPrice: eur, IncludeDeleted: true, IncludeUnpublished: true},
+			want:     "WHERE p.currency = $1 AND p.price_unit >= $2 AND p.currency = $3 AND p.price_unit <= $4",
+			wantArgs: "[EUR 5.00 EUR EUR 20.00 EUR]",
+		},
+		{
+			name:    "mixed currencies",
+			filter:  ProductFilter{MinPrice: usd, MaxPrice: eur},
+			wantErr: true,
+		},
+		{
+			name:    "min above max",
+			filter:  ProductFilter{MinPrice: &Money{3000, "EUR"}, MaxPrice: eur},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var args queryArgs
+			if got := tt.filter.where(&args); got != tt.want {
+				t.Errorf("where() = %q, want %q", got, tt.want)
+			}
+			if got := fmt.Sprint(args); got != tt.wantArgs {
+				t.Errorf("args = %s, want %s", got, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestProductOrderByPrice(t *testing.T) {
+	sort, err := ParseProductSort("-price_unit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := ListOptions{Sort: sort}.orderBy()
+	if want := "ORDER BY p.currency, p.price_unit DESC, p.product_id"; got != want {
+		t.Errorf("orderBy() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,702 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"strconv"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/jackc/pgtype"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"product/blob"
+)
+
+// ImageSize is one of the sizes thumbnails are made in. A thumbnail fits
+// within Max by Max pixels, keeping the image's aspect ratio.
+type ImageSize struct {
+	Name string
+	Max  int
+}
+
+// ImageSizes are the thumbnails made of every uploaded image, each as a JPEG
+// and a lossless WebP.
+var ImageSizes = []ImageSize{
+	{Name: "thumb", Max: 150},
+	{Name: "small", Max: 320},
+	{Name: "medium", Max: 640},
+	{Name: "large", Max: 1280},
+}
+
+const (
+	// MaxImageBytes caps the size of one uploaded image.
+	MaxImageBytes = 20 << 20
+
+	// maxImagePixels caps the pixels of an uploaded image, so that a small
+	// file cannot decode to an enormous one.
+	maxImagePixels = 25_000_000
+
+	jpegQuality = 85
+
+	// imageTimeout bounds an image change, which also writes to the blob
+	// store.
+	imageTimeout = 30 * time.Second
+
+	// primaryImageSize is the thumbnail a product's or category's ImageURL
+	// points at once it has an uploaded image.
+	primaryImageSize = "large"
+)
+
+// ErrUnsupportedImage is returned for an upload that is not an image in a
+// supported format.
+var ErrUnsupportedImage = errors.New("image must be a JPEG, PNG, GIF or WebP")
+
+// imageFormats maps the formats uploads are accepted in, as named by
+// image.Decode, to their content type and file extension.
+var imageFormats = map[string]struct{ contentType, ext string }{
+	"jpeg": {"image/jpeg", ".jpg"},
+	"png":  {"image/png", ".png"},
+	"gif":  {"image/gif", ".gif"},
+	"webp": {"image/webp", ".webp"},
+}
+
+// ProductImage is one of the ordered images of a product. URL is the
+// original upload; Sizes holds the URLs of its thumbnails by size name.
+type ProductImage struct {
+	ID          int                  `json:"imageId"`
+	ProductID   int                  `json:"productId"`
+	Position    int                  `json:"position"`
+	ContentType string               `json:"contentType"`
+	Width       int                  `json:"width"`
+	Height      int                  `json:"height"`
+	URL         string               `json:"url"`
+	Sizes       map[string]ImageURLs `json:"sizes"`
+	CreatedAt   time.Time            `json:"createdAt"`
+}
+
+// ImageURLs are the URLs of one thumbnail size in each format.
+type ImageURLs struct {
+	JPEG string `json:"jpeg"`
+	WebP string `json:"webp"`
+}
+
+// ImageModel stores uploaded images and their thumbnails in Store, and
+// records them in the database.
+type ImageModel struct {
+	DB    *sql.DB
+	Store blob.Store
+}
+
+// processedImage is an upload decoded and resized, ready to be stored.
+type processedImage struct {
+	contentType string
+	width       int
+	height      int
+	// files holds the contents to store by file name, such as "large.jpg".
+	files map[string][]byte
+}
+
+// processImage checks that upload is a supported image and makes its
+// thumbnails.
+func processImage(upload []byte) (*processedImage, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	f, ok := imageFormats[format]
+	if !ok {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width < 1 || cfg.Height < 1 {
+		return nil, fmt.Errorf("%w: image is empty", ErrUnsupportedImage)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("%w: image is larger than %d megapixels", ErrUnsupportedImage, maxImagePixels/1_000_000)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(upload))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	p := processedImage{
+		contentType: f.contentType,
+		width:       cfg.Width,
+		height:      cfg.Height,
+		files:       map[string][]byte{"original" + f.ext: upload},
+	}
+
+	for _, size := range ImageSizes {
+		thumb := resizeImage(img, size.Max)
+
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, flattenImage(thumb), &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return nil, err
+		}
+		p.files[size.Name+".jpg"] = buf.Bytes()
+
+		var webp bytes.Buffer
+		err = nativewebp.Encode(&webp, thumb, nil)
+		if err != nil {
+			return nil, err
+		}
+		p.files[size.Name+".webp"] = webp.Bytes()
+	}
+
+	return &p, nil
+}
+
+// resizeImage scales img down to fit within limit by limit pixels. Images
+// that already fit are returned as they are, never scaled up.
+func resizeImage(img image.Image, limit int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= limit && h <= limit {
+		return img
+	}
+
+	if w >= h {
+		w, h = limit, max(1, h*limit/w)
+	} else {
+		w, h = max(1, w*limit/h), limit
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// flattenImage draws img over white, since JPEG has no transparency.
+func flattenImage(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// put writes the files of p to the store under prefix.
+func (m *ImageModel) put(ctx context.Context, prefix string, p *processedImage) error {
+	for name, contents := range p.files {
+		err := m.Store.Put(ctx, prefix+name, bytes.NewReader(contents))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func productImagePrefix(productID int) string {
+	return "products/" + strconv.Itoa(productID) + "/"
+}
+
+func imagePrefix(productID, imageID int) string {
+	return productImagePrefix(productID) + strconv.Itoa(imageID) + "/"
+}
+
+func categoryImagePrefix(categoryID int) string {
+	return "categories/" + strconv.Itoa(categoryID) + "/"
+}
+
+// setURLs fills in the URLs of img from the store.
+func (m *ImageModel) setURLs(img *ProductImage) {
+	prefix := imagePrefix(img.ProductID, img.ID)
+
+	ext := ""
+	for _, f := range imageFormats {
+		if f.contentType == img.ContentType {
+			ext = f.ext
+		}
+	}
+
+	img.URL = m.Store.URL(prefix + "original" + ext)
+	img.Sizes = map[string]ImageURLs{}
+	for _, size := range ImageSizes {
+		img.Sizes[size.Name] = ImageURLs{
+			JPEG: m.Store.URL(prefix + size.Name + ".jpg"),
+			WebP: m.Store.URL(prefix + size.Name + ".webp"),
+		}
+	}
+}
+
+// listImages returns the images of the products with the given IDs, in
+// order.
+func (m *ImageModel) listImages(ctx context.Context, q queryer, productIDs ...int) ([]*ProductImage, error) {
+	ids := make([]int32, 0, len(productIDs))
+	for _, id := range productIDs {
+		ids = append(ids, int32(id))
+	}
+
+	var idArray pgtype.Int4Array
+	err := idArray.Set(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT image_id, product_id, position, content_type, width, height, created_at
+		FROM product_images
+		WHERE product_id = ANY($1)
+		ORDER BY product_id, position
+	`
+
+	rows, err := q.QueryContext(ctx, query, &idArray)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*ProductImage{}
+
+	for rows.Next() {
+		var img ProductImage
+
+		err := rows.Scan(
+			&img.ID,
+			&img.ProductID,
+			&img.Position,
+			&img.ContentType,
+			&img.Width,
+			&img.Height,
+			&img.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		m.setURLs(&img)
+		images = append(images, &img)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// GetAll returns the images of a product, in order.
+func (m *ImageModel) GetAll(productID int) ([]*ProductImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return m.listImages(ctx, m.DB, productID)
+}
+
+// Load sets the images of products.
+func (m *ImageModel) Load(products ...*Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	ids := make([]int, 0, len(products))
+	byID := map[int]*Product{}
+	for _, p := range products {
+		ids = append(ids, p.ID)
+		byID[p.ID] = p
+		p.Images = []*ProductImage{}
+	}
+
+	images, err := m.listImages(ctx, m.DB, ids...)
+	if err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		p := byID[img.ProductID]
+		p.Images = append(p.Images, img)
+	}
+
+	return nil
+}
+
+// Add stores uploads as new images of a product, after any it already has.
+// Every upload is checked before any is saved, so either all of them are
+// added or none. It returns sql.ErrNoRows if the product does not exist.
+func (m *ImageModel) Add(productID int, uploads ...[]byte) ([]*ProductImage, error) {
+	processed := make([]*processedImage, 0, len(uploads))
+	for i, upload := range uploads {
+		p, err := processImage(upload)
+		if err != nil {
+			return nil, fmt.Errorf("image %d: %w", i+1, err)
+		}
+		processed = append(processed, p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), imageTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockProduct(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The files of images that end up not being saved are removed again
+	var prefixes []string
+	committed := false
+	defer func() {
+		if !committed {
+			for _, prefix := range prefixes {
+				_ = m.Store.DeletePrefix(context.Background(), prefix)
+			}
+		}
+	}()
+
+	added := make([]*ProductImage, 0, len(processed))
+
+	for _, p := range processed {
+		img := ProductImage{
+			ProductID:   productID,
+			ContentType: p.contentType,
+			Width:       p.width,
+			Height:      p.height,
+			CreatedAt:   time.Now(),
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO product_images (product_id, position, content_type, width, height, created_at)
+			VALUES ($1, (SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id = $1), $2, $3, $4, $5)
+			RETURNING image_id, position
+		`, productID, img.ContentType, img.Width, img.Height, img.CreatedAt).Scan(&img.ID, &img.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := imagePrefix(productID, img.ID)
+		prefixes = append(prefixes, prefix)
+
+		err = m.put(ctx, prefix, p)
+		if err != nil {
+			return nil, err
+		}
+
+		m.setURLs(&img)
+		added = append(added, &img)
+	}
+
+	err = m.syncProductImage(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+
+	return added, nil
+}
+
+// Delete removes an image of a product and its files. The images after it
+// move up. It returns sql.ErrNoRows if the product has no such image.
+func (m *ImageModel) Delete(productID, imageID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), imageTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockProduct(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	var position int
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM product_images WHERE product_id = $1 AND image_id = $2 RETURNING position`,
+		productID, imageID,
+	).Scan(&position)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE product_images SET position = position - 1 WHERE product_id = $1 AND position > $2`,
+		productID, position,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = m.syncProductImage(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Left behind, the files only take up space, so the delete has still
+	// succeeded if they cannot be removed
+	_ = m.Store.DeletePrefix(ctx, imagePrefix(productID, imageID))
+
+	return nil
+}
+
+// Reorder puts the images of a product in the order of imageIDs, which must
+// list each of them once.
+func (m *ImageModel) Reorder(productID int, imageIDs []int) ([]*ProductImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockProduct(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := m.listImages(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	errOrder := errors.New("imageIds must list each of the product's images once")
+	if len(imageIDs) != len(current) {
+		return nil, errOrder
+	}
+
+	listed := map[int]bool{}
+	for _, img := range current {
+		listed[img.ID] = false
+	}
+	for _, id := range imageIDs {
+		done, ok := listed[id]
+		if !ok || done {
+			return nil, errOrder
+		}
+		listed[id] = true
+	}
+
+	ids := make([]int32, 0, len(imageIDs))
+	for _, id := range imageIDs {
+		ids = append(ids, int32(id))
+	}
+
+	var idArray pgtype.Int4Array
+	err = idArray.Set(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Positions are unique per product only once the transaction commits,
+	// so they can be swapped in one statement
+	_, err = tx.ExecContext(ctx, `
+		UPDATE product_images i
+		SET position = o.ordinality - 1
+		FROM unnest($1::int[]) WITH ORDINALITY AS o(image_id, ordinality)
+		WHERE i.image_id = o.image_id AND i.product_id = $2
+	`, &idArray, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.syncProductImage(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := m.listImages(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// DeleteProductFiles removes the files of every image of a deleted product.
+func (m *ImageModel) DeleteProductFiles(productID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), imageTimeout)
+	defer cancel()
+
+	return m.Store.DeletePrefix(ctx, productImagePrefix(productID))
+}
+
+// syncProductImage points the product's ImageURL at its first image, if it
+// has one. An ImageURL that was set by hand to an image stored elsewhere is
+// left alone.
+func (m *ImageModel) syncProductImage(ctx context.Context, tx *sql.Tx, productID int) error {
+	url := ""
+
+	var imageID int
+	err := tx.QueryRowContext(ctx,
+		`SELECT image_id FROM product_images WHERE product_id = $1 ORDER BY position LIMIT 1`,
+		productID,
+	).Scan(&imageID)
+	if err == nil {
+		url = m.Store.URL(imagePrefix(productID, imageID) + primaryImageSize + ".jpg")
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return updateAndEnqueue(ctx, tx, EventProductUpdated, AggregateProduct, `
+		UPDATE products SET image_url = $1, updated_at = $2
+		WHERE product_id = $3 AND image_url IS DISTINCT FROM $1
+		  AND (COALESCE(image_url, '') = '' OR starts_with(image_url, $4))
+		RETURNING product_id
+	`, url, time.Now(), productID, m.Store.URL(productImagePrefix(productID)))
+}
+
+// lockProduct locks a product's row for the rest of tx, returning
+// sql.ErrNoRows if it does not exist or is archived.
+func lockProduct(ctx context.Context, tx *sql.Tx, productID int) error {
+	var id int
+	return tx.QueryRowContext(ctx, `SELECT product_id FROM products WHERE product_id = $1 AND deleted_at IS NULL FOR UPDATE`, productID).Scan(&id)
+}
+
+// SetCategoryImage stores upload as the image of a category, replacing any
+// it had, and points the category's ImageURL at it. It returns
+// sql.ErrNoRows if the category does not exist.
+func (m *ImageModel) SetCategoryImage(categoryID int, upload []byte) error {
+	p, err := processImage(upload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), imageTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldKey sql.NullString
+	err = tx.QueryRowContext(ctx,
+		`SELECT image_key FROM categories WHERE category_id = $1 FOR UPDATE`,
+		categoryID,
+	).Scan(&oldKey)
+	if err != nil {
+		return err
+	}
+
+	// A new key each time, so that clients caching the old image see the change
+	key := categoryImagePrefix(categoryID) + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = m.Store.DeletePrefix(context.Background(), key)
+		}
+	}()
+
+	err = m.put(ctx, key, p)
+	if err != nil {
+		return err
+	}
+
+	err = updateAndEnqueue(ctx, tx, EventCategoryUpdated, AggregateCategory, `
+		UPDATE categories SET image_url = $1, image_key = $2, updated_at = $3
+		WHERE category_id = $4
+		RETURNING category_id
+	`, m.Store.URL(key+primaryImageSize+".jpg"), key, time.Now(), categoryID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	committed = true
+
+	if oldKey.Valid {
+		_ = m.Store.DeletePrefix(ctx, oldKey.String)
+	}
+
+	return nil
+}
+
+// DeleteCategoryImage removes the uploaded image of a category and clears
+// its ImageURL. An ImageURL set by hand is left alone.
+func (m *ImageModel) DeleteCategoryImage(categoryID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), imageTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldKey sql.NullString
+	err = tx.QueryRowContext(ctx,
+		`SELECT image_key FROM categories WHERE category_id = $1 FOR UPDATE`,
+		categoryID,
+	).Scan(&oldKey)
+	if err != nil {
+		return err
+	}
+	if !oldKey.Valid {
+		return nil
+	}
+
+	err = updateAndEnqueue(ctx, tx, EventCategoryUpdated, AggregateCategory, `
+		UPDATE categories
+		SET image_url = CASE WHEN starts_with(image_url, $1) THEN '' ELSE image_url END, image_key = NULL, updated_at = $2
+		WHERE category_id = $3
+		RETURNING category_id
+	`, m.Store.URL(oldKey.String), time.Now(), categoryID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	_ = m.Store.DeletePrefix(ctx, oldKey.String)
+
+	return nil
+}
+
+// DeleteCategoryFiles removes the uploaded images of deleted categories.
+func (m *ImageModel) DeleteCategoryFiles(categoryIDs ...int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), imageTimeout)
+	defer cancel()
+
+	for _, id := range categoryIDs {
+		err := m.Store.DeletePrefix(ctx, categoryImagePrefix(id))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,667 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats accepted by an import.
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+// Statuses of an import job.
+const (
+	ImportQueued    = "queued"
+	ImportRunning   = "running"
+	ImportSucceeded = "succeeded"
+	ImportFailed    = "failed"
+)
+
+const (
+	// importBatchSize is how many rows are written per transaction.
+	importBatchSize = 500
+
+	// importBatchTimeout bounds the time one batch may take.
+	importBatchTimeout = 60 * time.Second
+
+	// maxImportErrors caps the row errors kept in a job's report. Rows past
+	// it are still counted as failed.
+	maxImportErrors = 1000
+
+	// maxImportLine caps the length of one NDJSON line.
+	maxImportLine = 1 << 20
+)
+
+// importColumns are the CSV columns, and NDJSON keys, of an import. Only
+// sku, productTitle and priceUnit are required; a product updated from a row
+// keeps its values for columns the row leaves out or empty.
+var importColumns = []string{"sku", "productTitle", "priceUnit", "currency", "quantity", "category", "imageUrl", "lowStockThreshold"}
+
+// ImportJob tracks an import of products from a file.
+type ImportJob struct {
+	ID         int           `json:"jobId"`
+	Status     string        `json:"status"`
+	Format     string        `json:"format"`
+	DryRun     bool          `json:"dryRun"`
+	Rows       int           `json:"rows"`
+	Created    int           `json:"created"`
+	Updated    int           `json:"updated"`
+	Failed     int           `json:"failed"`
+	Errors     []ImportError `json:"errors"`
+	Message    string        `json:"message,omitempty"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	StartedAt  *time.Time    `json:"startedAt"`
+	FinishedAt *time.Time    `json:"finishedAt"`
+}
+
+// ImportError reports why one row of an import was not saved.
+type ImportError struct {
+	Line    int    `json:"line"`
+	SKU     string `json:"sku,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// importRow is one product read from an import file. Optional fields are nil
+// when the row leaves them out, and Price has no currency when the row names
+// none.
+type importRow struct {
+	Line              int
+	SKU               string
+	Title             string
+	Price             Money
+	Quantity          *int
+	Category          *string
+	ImageURL          *string
+	LowStockThreshold *int
+}
+
+type ImportModel struct {
+	DB *sql.DB
+}
+
+// CreateJob records a new import job waiting to run.
+func (m *ImportModel) CreateJob(format string, dryRun bool) (*ImportJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if format != ImportFormatCSV && format != ImportFormatNDJSON {
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+
+	job := ImportJob{
+		Status:    ImportQueued,
+		Format:    format,
+		DryRun:    dryRun,
+		Errors:    []ImportError{},
+		CreatedAt: time.Now(),
+	}
+
+	err := m.DB.QueryRowContext(ctx, `
+		INSERT INTO import_jobs (status, format, dry_run, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING job_id
+	`, job.Status, job.Format, job.DryRun, job.CreatedAt).Scan(&job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (m *ImportModel) GetJob(id int) (*ImportJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT job_id, status, format, dry_run, rows_read, created, updated, failed, errors, COALESCE(message, ''), created_at, started_at, finished_at
+		FROM import_jobs
+		WHERE job_id = $1
+	`
+
+	var job ImportJob
+	var errs []byte
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&job.ID,
+		&job.Status,
+		&job.Format,
+		&job.DryRun,
+		&job.Rows,
+		&job.Created,
+		&job.Updated,
+		&job.Failed,
+		&errs,
+		&job.Message,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(errs, &job.Errors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// FailInterrupted marks jobs left queued or running by a previous process as
+// failed, since their files went with it. It returns how many it marked.
+func (m *ImportModel) FailInterrupted() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `
+		UPDATE import_jobs SET status = $1, message = 'interrupted by a restart', finished_at = $2
+		WHERE status IN ($3, $4)
+	`, ImportFailed, time.Now(), ImportQueued, ImportRunning)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Run imports the products in r into job, upserting them by SKU in batched
+// transactions. A row that fails is reported in the job and skipped; the
+// rest of its batch is saved. A dry run checks every row the same way but
+// saves nothing. The job's progress is saved after every batch, and job is
+// left as it finished.
+func (m *ImportModel) Run(job *ImportJob, r io.Reader) error {
+	now := time.Now()
+	job.Status = ImportRunning
+	job.StartedAt = &now
+
+	err := m.saveJob(job)
+	if err != nil {
+		return err
+	}
+
+	err = m.run(job, r)
+
+	// Rows that fail to parse are reported before those that fail to save
+	sort.SliceStable(job.Errors, func(i, j int) bool {
+		return job.Errors[i].Line < job.Errors[j].Line
+	})
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.Status = ImportSucceeded
+	if err != nil {
+		job.Status = ImportFailed
+		job.Message = err.Error()
+	}
+
+	saveErr := m.saveJob(job)
+	if err != nil {
+		return err
+	}
+	return saveErr
+}
+
+func (m *ImportModel) run(job *ImportJob, r io.Reader) error {
+	var next func() (*importRow, error)
+	var err error
+
+	switch job.Format {
+	case ImportFormatCSV:
+		next, err = csvRows(r)
+	case ImportFormatNDJSON:
+		next = ndjsonRows(r)
+	default:
+		err = fmt.Errorf("unknown import format %q", job.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	categories := map[string]int{}
+	batch := make([]*importRow, 0, importBatchSize)
+
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var rowErr ImportError
+		if errors.As(err, &rowErr) {
+			job.Rows++
+			job.fail(rowErr)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		job.Rows++
+		batch = append(batch, row)
+
+		if len(batch) == importBatchSize {
+			err = m.importBatch(job, batch, categories)
+			if err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		err = m.importBatch(job, batch, categories)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (job *ImportJob) fail(e ImportError) {
+	job.Failed++
+	if len(job.Errors) < maxImportErrors {
+		job.Errors = append(job.Errors, e)
+	}
+}
+
+// importBatch saves rows in one transaction, each under a savepoint so a
+// failing row is rolled back alone. A dry run rolls back the whole batch.
+// categories caches category IDs by path across batches.
+func (m *ImportModel) importBatch(job *ImportJob, rows []*importRow, categories map[string]int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), importBatchTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var created, updated int
+	var failed []ImportError
+
+	for _, row := range rows {
+		_, err = tx.ExecContext(ctx, `SAVEPOINT import_row`)
+		if err != nil {
+			return err
+		}
+
+		isNew, err := importProduct(ctx, tx, row, categories)
+		if err != nil {
+			_, rbErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_row`)
+			if rbErr != nil {
+				return rbErr
+			}
+			failed = append(failed, ImportError{Line: row.Line, SKU: row.SKU, Message: err.Error()})
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT import_row`)
+		if err != nil {
+			return err
+		}
+
+		if isNew {
+			created++
+		} else {
+			updated++
+		}
+	}
+
+	if !job.DryRun {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Counted only once the batch is committed, so a failed commit is not reported as saved
+	job.Created += created
+	job.Updated += updated
+	for _, e := range failed {
+		job.fail(e)
+	}
+
+	return m.saveJob(job)
+}
+
+// importProduct creates the product of row, or updates the product with its
+// SKU, and reports whether it created one.
+func importProduct(ctx context.Context, tx *sql.Tx, row *importRow, categories map[string]int) (bool, error) {
+	query := `
+		SELECT ` + productColumns + `
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.category_id
+		WHERE lower(p.sku) = lower($1) AND p.deleted_at IS NULL
+		ORDER BY p.product_id
+		LIMIT 1
+		FOR UPDATE OF p
+	`
+
+	product, err := scanProduct(tx.QueryRowContext(ctx, query, row.SKU))
+	isNew := errors.Is(err, sql.ErrNoRows)
+	if isNew {
+		product = &Product{SKU: row.SKU}
+	} else if err != nil {
+		return false, err
+	}
+
+	price := row.Price
+	if price.Currency == "" && !isNew {
+		price, err = price.inCurrency(product.PriceUnit.Currency)
+		if err != nil {
+			return false, fmt.Errorf("priceUnit: %w", err)
+		}
+	}
+
+	product.Title = row.Title
+	product.PriceUnit = price
+
+	if row.Quantity != nil {
+		product.Quantity = *row.Quantity
+	}
+	if row.ImageURL != nil {
+		product.ImageURL = *row.ImageURL
+	}
+	if row.LowStockThreshold != nil {
+		product.LowStockThreshold = row.LowStockThreshold
+	}
+	if row.Category != nil {
+		id, err := resolveCategoryPath(ctx, tx, *row.Category, categories)
+		if err != nil {
+			return false, err
+		}
+		product.Category = &Category{ID: id}
+	}
+
+	if isNew {
+		_, err = insertProduct(ctx, tx, *product)
+	} else {
+		_, err = updateProduct(ctx, tx, *product)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return isNew, nil
+}
+
+// resolveCategoryPath finds the category at a path of titles from a root,
+// such as "Electronics/Phones".
+func resolveCategoryPath(ctx context.Context, q queryer, path string, cache map[string]int) (int, error) {
+	if id, ok := cache[path]; ok {
+		return id, nil
+	}
+
+	var parentID *int
+	for _, title := range strings.Split(path, "/") {
+		title = strings.TrimSpace(title)
+		if title == "" {
+			return 0, fmt.Errorf("invalid category path %q", path)
+		}
+
+		var id int
+		err := q.QueryRowContext(ctx, `
+			SELECT category_id FROM categories
+			WHERE category_title = $1 AND parent_category_id IS NOT DISTINCT FROM $2::int
+			ORDER BY category_id
+			LIMIT 1
+		`, title, parentID).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("unknown category %q in path %q", title, path)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		parentID = &id
+	}
+
+	cache[path] = *parentID
+	return *parentID, nil
+}
+
+func (m *ImportModel) saveJob(job *ImportJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	errs, err := json.Marshal(job.Errors)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `
+		UPDATE import_jobs
+		SET status = $1, rows_read = $2, created = $3, updated = $4, failed = $5, errors = $6, message = $7, started_at = $8, finished_at = $9
+		WHERE job_id = $10
+	`,
+		job.Status,
+		job.Rows,
+		job.Created,
+		job.Updated,
+		job.Failed,
+		errs,
+		sql.NullString{String: job.Message, Valid: job.Message != ""},
+		job.StartedAt,
+		job.FinishedAt,
+		job.ID,
+	)
+	return err
+}
+
+// csvRows reads rows from CSV with a header line naming its columns.
+func csvRows(r io.Reader) (func() (*importRow, error), error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("import file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !slices.Contains(importColumns, name) {
+			return nil, fmt.Errorf("unknown column %q, columns are %s", name, strings.Join(importColumns, ", "))
+		}
+		index[name] = i
+	}
+	for _, name := range []string{"sku", "productTitle", "priceUnit"} {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	return func() (*importRow, error) {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, ImportError{Line: parseErr.Line, Message: parseErr.Err.Error()}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		fields := map[string]string{}
+		for name, i := range index {
+			if v := strings.TrimSpace(record[i]); v != "" {
+				fields[name] = v
+			}
+		}
+
+		row, err := parseImportRow(line, fields)
+		if err != nil {
+			return nil, ImportError{Line: line, SKU: fields["sku"], Message: err.Error()}
+		}
+
+		return row, nil
+	}, nil
+}
+
+// ndjsonRows reads rows from newline delimited JSON, one object per line.
+func ndjsonRows(r io.Reader) func() (*importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+	line := 0
+
+	return func() (*importRow, error) {
+		for scanner.Scan() {
+			line++
+
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+
+			dec := json.NewDecoder(bytes.NewReader(text))
+			dec.UseNumber()
+
+			var obj map[string]any
+			err := dec.Decode(&obj)
+			if err != nil {
+				return nil, ImportError{Line: line, Message: "invalid JSON: " + err.Error()}
+			}
+
+			fields := map[string]string{}
+			for name, v := range obj {
+				if !slices.Contains(importColumns, name) {
+					return nil, ImportError{Line: line, Message: fmt.Sprintf("unknown field %q", name)}
+				}
+
+				switch v := v.(type) {
+				case nil:
+				case string:
+					if s := strings.TrimSpace(v); s != "" {
+						fields[name] = s
+					}
+				case json.Number:
+					fields[name] = v.String()
+				case map[string]any:
+					// A price given as {"amount": ..., "currency": ...}
+					if name != "priceUnit" {
+						return nil, ImportError{Line: line, Message: fmt.Sprintf("%s must be a string or number", name)}
+					}
+					if amount, ok := v["amount"]; ok {
+						fields[name] = fmt.Sprint(amount)
+					}
+					if currency, ok := v["currency"].(string); ok && obj["currency"] == nil {
+						fields["currency"] = currency
+					}
+				default:
+					return nil, ImportError{Line: line, Message: fmt.Sprintf("%s must be a string or number", name)}
+				}
+			}
+
+			row, err := parseImportRow(line, fields)
+			if err != nil {
+				return nil, ImportError{Line: line, SKU: fields["sku"], Message: err.Error()}
+			}
+
+			return row, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			if errors.Is(err, bufio.ErrTooLong) {
+				return nil, fmt.Errorf("line %d is longer than %d bytes", line+1, maxImportLine)
+			}
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+}
+
+// parseImportRow builds a row from its non-empty fields by column name.
+func parseImportRow(line int, fields map[string]string) (*importRow, error) {
+	row := importRow{
+		Line:  line,
+		SKU:   fields["sku"],
+		Title: fields["productTitle"],
+	}
+
+	if row.SKU == "" {
+		return nil, errors.New("sku is required")
+	}
+	if problem := validateSKU(row.SKU); problem != "" {
+		return nil, fmt.Errorf("sku %q %s", row.SKU, problem)
+	}
+	if row.Title == "" {
+		return nil, errors.New("productTitle is required")
+	}
+	if fields["priceUnit"] == "" {
+		return nil, errors.New("priceUnit is required")
+	}
+
+	currency := fields["currency"]
+	if currency != "" {
+		err := ValidateCurrency(currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	row.Price, err = ParseMoney(fields["priceUnit"], currency)
+	if err != nil {
+		return nil, fmt.Errorf("priceUnit: %w", err)
+	}
+	if row.Price.IsNegative() {
+		return nil, errors.New("priceUnit must not be negative")
+	}
+
+	// Without a currency the price is in the product's, which is only known
+	// once the row is matched to a product
+	row.Price.Currency = currency
+
+	for name, dest := range map[string]**int{"quantity": &row.Quantity, "lowStockThreshold": &row.LowStockThreshold} {
+		v, ok := fields[name]
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s must be a whole number, not %q", name, v)
+		}
+		*dest = &n
+	}
+
+	if v, ok := fields["category"]; ok {
+		row.Category = &v
+	}
+	if v, ok := fields["imageUrl"]; ok {
+		row.ImageURL = &v
+	}
+
+	return &row, nil
+}
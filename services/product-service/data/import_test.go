@@ -0,0 +1,80 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestParseImportRow(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    map[string]string
+		wantPrice Money
+		wantErr   bool
+	}{
+		{
+			name:      "with currency",
+			fields:    map[string]string{"sku": "TSHIRT-RED", "productTitle": "T-shirt", "priceUnit": "19.99", "currency": "EUR"},
+			wantPrice: Money{1999, "EUR"},
+		},
+		{
+			name:      "without currency",
+			fields:    map[string]string{"sku": "TSHIRT-RED", "productTitle": "T-shirt", "priceUnit": "19.99"},
+			wantPrice: Money{1999, ""},
+		},
+		{
+			name:      "zero decimal currency",
+			fields:    map[string]string{"sku": "TSHIRT-RED", "productTitle": "T-shirt", "priceUnit": "1500", "currency": "JPY"},
+			wantPrice: Money{1500, "JPY"},
+		},
+		{
+			name:    "no sku",
+			fields:  map[string]string{"productTitle": "T-shirt", "priceUnit": "19.99"},
+			wantErr: true,
+		},
+		{
+			name:    "bad sku",
+			fields:  map[string]string{"sku": "T SHIRT", "productTitle": "T-shirt", "priceUnit": "19.99"},
+			wantErr: true,
+		},
+		{
+			name:    "no title",
+			fields:  map[string]string{"sku": "TSHIRT-RED", "priceUnit": "19.99"},
+			wantErr: true,
+		},
+		{
+			name:    "no price",
+			fields:  map[string]string{"sku": "TSHIRT-RED", "productTitle": "T-shirt"},
+			wantErr: true,
+		},
+		{
+			name:    "bad currency",
+			fields:  map[string]string{"sku": "TSHIRT-RED", "productTitle": "T-shirt", "priceUnit": "19.99", "currency": "eur"},
+			wantErr: true,
+		},
+		{
+			name:    "negative price",
+			fields:  map[string]string{"sku": "TSHIRT-RED", "productTitle": "T-shirt", "priceUnit": "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "bad quantity",
+			fields:  map[string]string{"sku": "TSHIRT-RED", "productTitle": "T-shirt", "priceUnit": "19.99", "quantity": "1.5"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, err := parseImportRow(2, tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseImportRow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if row.Price != tt.wantPrice {
+				t.Errorf("Price = %v, want %v", row.Price, tt.wantPrice)
+			}
+		})
+	}
+}
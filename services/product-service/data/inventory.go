@@ -0,0 +1,510 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// Reasons recorded against an inventory movement.
+const (
+	ReasonRestock     = "restock"
+	ReasonSale        = "sale"
+	ReasonReturn      = "return"
+	ReasonAdjustment  = "adjustment"
+	ReasonReservation = "reservation"
+	ReasonTransfer    = "transfer"
+)
+
+// SystemActor is recorded as the actor of movements the service makes on its
+// own behalf.
+const SystemActor = "system"
+
+// InventoryMovement is one entry in the append-only stock ledger of a
+// product.
+type InventoryMovement struct {
+	ID            int64     `json:"movementId"`
+	ProductID     int       `json:"productId"`
+	VariantID     *int      `json:"variantId,omitempty"`
+	Change        int       `json:"change"`
+	QuantityAfter int       `json:"quantityAfter"`
+	Reason        string    `json:"reason"`
+	Actor         string    `json:"actor"`
+	ReferenceID   string    `json:"referenceId,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	// WarehouseID is the warehouse a movement is made in, if it names one.
+	WarehouseID *int `json:"-"`
+	// Warehouses is how the change fell on each warehouse.
+	Warehouses []WarehouseChange `json:"warehouses"`
+	// TransferID pairs the two movements of a transfer between warehouses.
+	TransferID *int64 `json:"transferId,omitempty"`
+}
+
+// Transfer moves stock of a product from one warehouse to another.
+type Transfer struct {
+	ProductID       int    `json:"productId"`
+	FromWarehouseID int    `json:"fromWarehouseId"`
+	ToWarehouseID   int    `json:"toWarehouseId"`
+	Quantity        int    `json:"quantity"`
+	Actor           string `json:"actor"`
+	ReferenceID     string `json:"referenceId"`
+}
+
+// Validate checks the transfer on its own.
+func (t Transfer) Validate() error {
+	var v ValidationError
+
+	if t.Quantity < 1 {
+		v.Add("quantity", "must be at least 1")
+	}
+	if t.FromWarehouseID == t.ToWarehouseID {
+		v.Add("toWarehouseId", "must differ from fromWarehouseId")
+	}
+	if t.Actor == "" {
+		v.Add("actor", "is required")
+	}
+
+	return v.Err()
+}
+
+// TransferResult is the pair of movements recording a transfer.
+type TransferResult struct {
+	TransferID int64              `json:"transferId"`
+	Out        *InventoryMovement `json:"out"`
+	In         *InventoryMovement `json:"in"`
+}
+
+// StockLevel compares a product's stored quantity with the sum of its ledger
+// and the stock held in its warehouses.
+type StockLevel struct {
+	ProductID         int              `json:"productId"`
+	Quantity          int              `json:"quantity"`
+	LedgerQuantity    int              `json:"ledgerQuantity"`
+	WarehouseQuantity int              `json:"warehouseQuantity"`
+	Reserved          int              `json:"reserved"`
+	Available         int              `json:"available"`
+	Consistent        bool             `json:"consistent"`
+	Warehouses        []WarehouseStock `json:"warehouses"`
+}
+
+// Validate checks that the change goes in the direction its reason implies.
+func (mv InventoryMovement) Validate() error {
+	if mv.Change == 0 {
+		return errors.New("change must not be zero")
+	}
+	if mv.Actor == "" {
+		return errors.New("actor is required")
+	}
+
+	switch mv.Reason {
+	case ReasonRestock, ReasonReturn:
+		if mv.Change < 0 {
+			return fmt.Errorf("a %s must increase stock", mv.Reason)
+		}
+	case ReasonSale, ReasonReservation:
+		if mv.Change > 0 {
+			return fmt.Errorf("a %s must decrease stock", mv.Reason)
+		}
+	case ReasonAdjustment, ReasonTransfer:
+	default:
+		return fmt.Errorf("unknown movement reason %q", mv.Reason)
+	}
+
+	return nil
+}
+
+type InventoryModel struct {
+	DB *sql.DB
+}
+
+// Record applies a movement to its product's quantity and appends it to the
+// ledger. It returns ErrInsufficientStock if the change would take quantity
+// below what is currently reserved.
+func (m *InventoryModel) Record(mv InventoryMovement) (*InventoryMovement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := mv.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	recorded, err := recordMovement(ctx, tx, mv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return recorded, nil
+}
+
+// recordMovement is Record within an existing transaction. A movement of a
+// variant changes the variant's stock and its product's total alike.
+func recordMovement(ctx context.Context, tx *sql.Tx, mv InventoryMovement) (*InventoryMovement, error) {
+	mv.CreatedAt = time.Now()
+
+	var variantQuantity int
+
+	if mv.VariantID != nil {
+		query := `
+			UPDATE product_variants
+			SET quantity = quantity + $1, updated_at = $2
+			WHERE variant_id = $3 AND product_id = $4 AND quantity + $1 >= reserved
+			RETURNING quantity
+		`
+
+		err := tx.QueryRowContext(ctx, query, mv.Change, mv.CreatedAt, *mv.VariantID, mv.ProductID).Scan(&variantQuantity)
+		if errors.Is(err, sql.ErrNoRows) {
+			var exists bool
+			err = tx.QueryRowContext(ctx,
+				`SELECT EXISTS (SELECT 1 FROM product_variants WHERE variant_id = $1 AND product_id = $2)`,
+				*mv.VariantID, mv.ProductID,
+			).Scan(&exists)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				return nil, sql.ErrNoRows
+			}
+			return nil, fmt.Errorf("%w for variant %d", ErrInsufficientStock, *mv.VariantID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var hasVariants bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1)`, mv.ProductID).Scan(&hasVariants)
+		if err != nil {
+			return nil, err
+		}
+		if hasVariants {
+			return nil, ErrVariantRequired
+		}
+	}
+
+	query := `
+		UPDATE products
+		SET quantity = COALESCE(quantity, 0) + $1, updated_at = $2
+		WHERE product_id = $3 AND COALESCE(quantity, 0) + $1 >= reserved
+		RETURNING quantity
+	`
+
+	err := tx.QueryRowContext(ctx, query, mv.Change, mv.CreatedAt, mv.ProductID).Scan(&mv.QuantityAfter)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE product_id = $1)`, mv.ProductID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, sql.ErrNoRows
+		}
+		return nil, fmt.Errorf("%w for product %d", ErrInsufficientStock, mv.ProductID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A variant's ledger entries track the variant's own quantity
+	if mv.VariantID != nil {
+		mv.QuantityAfter = variantQuantity
+	}
+
+	err = applyWarehouseChanges(ctx, tx, &mv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertMovement(ctx, tx, &mv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLowStock(ctx, tx, mv.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mv, nil
+}
+
+// insertMovement appends mv, with how it fell on each warehouse, to the
+// ledger.
+func insertMovement(ctx context.Context, tx *sql.Tx, mv *InventoryMovement) error {
+	query := `
+		INSERT INTO inventory_movements (product_id, variant_id, quantity_change, quantity_after, reason, actor, reference_id, transfer_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING movement_id
+	`
+
+	err := tx.QueryRowContext(ctx, query,
+		mv.ProductID,
+		mv.VariantID,
+		mv.Change,
+		mv.QuantityAfter,
+		mv.Reason,
+		mv.Actor,
+		sql.NullString{String: mv.ReferenceID, Valid: mv.ReferenceID != ""},
+		mv.TransferID,
+		mv.CreatedAt,
+	).Scan(&mv.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, wc := range mv.Warehouses {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO inventory_movement_warehouses (movement_id, warehouse_id, quantity_change, quantity_after)
+			VALUES ($1, $2, $3, $4)
+		`, mv.ID, wc.WarehouseID, wc.Change, wc.QuantityAfter)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Transfer moves stock of a product between warehouses, recorded as a pair
+// of transfer movements out of one and into the other. The product's total
+// quantity does not change. It returns ErrInsufficientStock if the source
+// warehouse does not hold enough, and ErrProductDeleted if the product is
+// archived.
+func (m *InventoryModel) Transfer(t Transfer) (*TransferResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := t.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the product serializes the transfer with its other movements
+	var quantity int
+	var deleted bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(quantity, 0), deleted_at IS NOT NULL FROM products WHERE product_id = $1 FOR UPDATE`,
+		t.ProductID,
+	).Scan(&quantity, &deleted)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, fmt.Errorf("%w: product %d", ErrProductDeleted, t.ProductID)
+	}
+
+	var transferID int64
+	err = tx.QueryRowContext(ctx, `SELECT nextval('inventory_transfer_seq')`).Scan(&transferID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TransferResult{TransferID: transferID}
+	now := time.Now()
+
+	for _, leg := range []struct {
+		warehouseID int
+		change      int
+		movement    **InventoryMovement
+	}{
+		{t.FromWarehouseID, -t.Quantity, &result.Out},
+		{t.ToWarehouseID, t.Quantity, &result.In},
+	} {
+		mv := &InventoryMovement{
+			ProductID:     t.ProductID,
+			Change:        leg.change,
+			QuantityAfter: quantity,
+			Reason:        ReasonTransfer,
+			Actor:         t.Actor,
+			ReferenceID:   t.ReferenceID,
+			CreatedAt:     now,
+			WarehouseID:   &leg.warehouseID,
+			TransferID:    &transferID,
+		}
+
+		err = applyWarehouseChanges(ctx, tx, mv)
+		if err != nil {
+			return nil, err
+		}
+
+		err = insertMovement(ctx, tx, mv)
+		if err != nil {
+			return nil, err
+		}
+
+		*leg.movement = mv
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// History returns one page of a product's movements, newest first, along with
+// the total number of movements.
+func (m *InventoryModel) History(productID int, opts ListOptions) ([]*InventoryMovement, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var total int
+	err := m.DB.QueryRowContext(ctx, `SELECT count(*) FROM inventory_movements WHERE product_id = $1`, productID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT movement_id, product_id, variant_id, quantity_change, quantity_after, reason, actor, reference_id, transfer_id, created_at
+		FROM inventory_movements
+		WHERE product_id = $1
+		ORDER BY movement_id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, productID, opts.Size, opts.offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	movements := []*InventoryMovement{}
+
+	for rows.Next() {
+		var mv InventoryMovement
+		var referenceID sql.NullString
+
+		err := rows.Scan(
+			&mv.ID,
+			&mv.ProductID,
+			&mv.VariantID,
+			&mv.Change,
+			&mv.QuantityAfter,
+			&mv.Reason,
+			&mv.Actor,
+			&referenceID,
+			&mv.TransferID,
+			&mv.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		mv.ReferenceID = referenceID.String
+		mv.Warehouses = []WarehouseChange{}
+		movements = append(movements, &mv)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	err = loadWarehouseChanges(ctx, m.DB, movements)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return movements, total, nil
+}
+
+// loadWarehouseChanges sets how each of movements fell on its warehouses.
+// Movements from before stock was kept per warehouse have none.
+func loadWarehouseChanges(ctx context.Context, q queryer, movements []*InventoryMovement) error {
+	if len(movements) == 0 {
+		return nil
+	}
+
+	byID := map[int64]*InventoryMovement{}
+	ids := make([]int64, len(movements))
+	for i, mv := range movements {
+		byID[mv.ID] = mv
+		ids[i] = mv.ID
+	}
+
+	var idArray pgtype.Int8Array
+	err := idArray.Set(ids)
+	if err != nil {
+		return err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT movement_id, warehouse_id, quantity_change, quantity_after
+		FROM inventory_movement_warehouses
+		WHERE movement_id = ANY($1)
+		ORDER BY movement_id, warehouse_id
+	`, &idArray)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var wc WarehouseChange
+		err := rows.Scan(&id, &wc.WarehouseID, &wc.Change, &wc.QuantityAfter)
+		if err != nil {
+			return err
+		}
+		byID[id].Warehouses = append(byID[id].Warehouses, wc)
+	}
+
+	return rows.Err()
+}
+
+// StockLevel reports a product's stored quantity next to the quantity its
+// ledger adds up to and the stock in each of its warehouses.
+func (m *InventoryModel) StockLevel(productID int) (*StockLevel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT p.product_id, COALESCE(p.quantity, 0), p.reserved,
+		       COALESCE((SELECT sum(quantity_change) FROM inventory_movements WHERE product_id = p.product_id), 0),
+		       COALESCE((SELECT sum(quantity) FROM warehouse_stock WHERE product_id = p.product_id), 0)
+		FROM products p
+		WHERE p.product_id = $1
+	`
+
+	var s StockLevel
+	err := m.DB.QueryRowContext(ctx, query, productID).Scan(
+		&s.ProductID,
+		&s.Quantity,
+		&s.Reserved,
+		&s.LedgerQuantity,
+		&s.WarehouseQuantity,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Warehouses, err = productWarehouseStock(ctx, m.DB, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Available = s.Quantity - s.Reserved
+	s.Consistent = s.Quantity == s.LedgerQuantity && s.Quantity == s.WarehouseQuantity
+
+	return &s, nil
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgtype"
+)
+
+// MaxLookupSize is the most IDs and SKUs a single lookup may ask for.
+const MaxLookupSize = 100
+
+// LookupResult is the outcome of looking up products by ID and SKU.
+type LookupResult struct {
+	Products []*Product `json:"collection"`
+	// MissingIDs and MissingSKUs are those asked for that match no product.
+	MissingIDs  []int    `json:"missingIds,omitempty"`
+	MissingSKUs []string `json:"missingSkus,omitempty"`
+}
+
+// GetBySKU returns the live product with the given SKU, or with a variant
+// with that SKU, matched without regard to case, with its options and
+// variants. It returns sql.ErrNoRows if there is none.
+func (m *ProductModel) GetBySKU(sku string) (*Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT product_id FROM products WHERE lower(sku) = lower($1) AND deleted_at IS NULL
+		UNION ALL
+		SELECT v.product_id
+		FROM product_variants v
+		JOIN products p ON p.product_id = v.product_id
+		WHERE lower(v.sku) = lower($1) AND p.deleted_at IS NULL
+		LIMIT 1
+	`, sku).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetOne(id, false)
+}
+
+// Lookup returns the live products with any of the given IDs or SKUs in a
+// single query, SKUs matched without regard to case. A variant SKU matches
+// the product the variant belongs to. Products come back in
+// the order they were asked for, each once, as they are listed by GetAll.
+// Products that are not published are only returned with includeUnpublished.
+func (m *ProductModel) Lookup(ids []int, skus []string, includeUnpublished bool) (*LookupResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	idValues := make([]int32, len(ids))
+	for i, id := range ids {
+		idValues[i] = int32(id)
+	}
+
+	var idArray pgtype.Int4Array
+	err := idArray.Set(idValues)
+	if err != nil {
+		return nil, err
+	}
+
+	lowered := make([]string, len(skus))
+	for i, sku := range skus {
+		lowered[i] = strings.ToLower(sku)
+	}
+
+	query := `
+		SELECT ` + productColumns + `,
+		       ARRAY(SELECT lower(v.sku) FROM product_variants v WHERE v.product_id = p.product_id AND lower(v.sku) = ANY($2))
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.category_id
+		WHERE p.deleted_at IS NULL
+		  AND (p.status = $3 OR $4)
+		  AND (p.product_id = ANY($1) OR lower(p.sku) = ANY($2)
+		   OR EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.product_id AND lower(v.sku) = ANY($2)))
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, &idArray, textArray(lowered), ProductPublished, includeUnpublished)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := map[int]*Product{}
+	bySKU := map[string]*Product{}
+
+	for rows.Next() {
+		var variantSKUs pgtype.TextArray
+
+		p, err := scanProduct(rows, &variantSKUs)
+		if err != nil {
+			return nil, err
+		}
+		byID[p.ID] = p
+		if p.SKU != "" {
+			bySKU[strings.ToLower(p.SKU)] = p
+		}
+
+		var matched []string
+		err = variantSKUs.AssignTo(&matched)
+		if err != nil {
+			return nil, err
+		}
+		for _, sku := range matched {
+			bySKU[sku] = p
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &LookupResult{Products: []*Product{}}
+	added := map[int]bool{}
+
+	add := func(p *Product) {
+		if !added[p.ID] {
+			added[p.ID] = true
+			result.Products = append(result.Products, p)
+		}
+	}
+
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			add(p)
+		} else {
+			result.MissingIDs = append(result.MissingIDs, id)
+		}
+	}
+	for i, sku := range skus {
+		if p, ok := bySKU[lowered[i]]; ok {
+			add(p)
+		} else {
+			result.MissingSKUs = append(result.MissingSKUs, sku)
+		}
+	}
+
+	return result, nil
+}
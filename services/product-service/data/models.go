@@ -3,42 +3,163 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
+
+	"product/blob"
 )
 
 const dbTimeout = time.Second * 3
 
+// ErrVersionMismatch is returned when an update expects a product or
+// category to be at a version it has since moved past.
+var ErrVersionMismatch = errors.New("modified since it was read")
+
 type Models struct {
-	Product  ProductModel
-	Category CategoryModel
+	Product     ProductModel
+	Category    CategoryModel
+	Reservation ReservationModel
+	Inventory   InventoryModel
+	Outbox      OutboxModel
+	Variant     VariantModel
+	PriceList   PriceListModel
+	Import      ImportModel
+	Image       ImageModel
+	Change      ChangeModel
+	Warehouse   WarehouseModel
 }
 
-func New(db *sql.DB) Models {
+func New(db *sql.DB, store blob.Store) Models {
 	return Models{
-		Product:  ProductModel{DB: db},
-		Category: CategoryModel{DB: db},
+		Product:     ProductModel{DB: db},
+		Category:    CategoryModel{DB: db},
+		Reservation: ReservationModel{DB: db},
+		Inventory:   InventoryModel{DB: db},
+		Outbox:      OutboxModel{DB: db},
+		Variant:     VariantModel{DB: db},
+		PriceList:   PriceListModel{DB: db},
+		Import:      ImportModel{DB: db},
+		Image:       ImageModel{DB: db, Store: store},
+		Change:      ChangeModel{DB: db},
+		Warehouse:   WarehouseModel{DB: db},
 	}
 }
 
 type Product struct {
-	ID        int       `json:"productId"`
-	Title     string    `json:"productTitle"`
-	ImageURL  string    `json:"imageUrl"`
-	SKU       string    `json:"sku"`
-	PriceUnit float64   `json:"priceUnit"`
-	Quantity  int       `json:"quantity"`
-	Category  *Category `json:"category"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID                int               `json:"productId"`
+	Title             string            `json:"productTitle"`
+	ImageURL          string            `json:"imageUrl"`
+	SKU               string            `json:"sku"`
+	PriceUnit         Money             `json:"priceUnit"`
+	SellingPrice      *SellingPrice     `json:"sellingPrice,omitempty"`
+	Quantity          int               `json:"quantity"`
+	Reserved          int               `json:"reserved"`
+	Available         int               `json:"available"`
+	LowStockThreshold *int              `json:"lowStockThreshold"`
+	Category          *Category         `json:"category"`
+	Attributes        map[string]any    `json:"attributes"`
+	Options           []ProductOption   `json:"options,omitempty"`
+	Variants          []*ProductVariant `json:"variants,omitempty"`
+	Images            []*ProductImage   `json:"images,omitempty"`
+	Status            string            `json:"status"`
+	PublishAt         *time.Time        `json:"publishAt"`
+	UnpublishAt       *time.Time        `json:"unpublishAt"`
+	DeletedAt         *time.Time        `json:"deletedAt,omitempty"`
+	CreatedAt         time.Time         `json:"-"`
+	UpdatedAt         time.Time         `json:"-"`
+	// Version goes up with every change to the product, its options,
+	// variants or images. When set on an update, the update only goes ahead
+	// if the product is still at that version.
+	Version int `json:"-"`
 }
 
 type Category struct {
-	ID             int       `json:"categoryId"`
-	Title          string    `json:"categoryTitle"`
-	ImageURL       string    `json:"imageUrl"`
-	ParentCategory *Category `json:"parentCategory,omitempty"`
-	CreatedAt      time.Time `json:"-"`
-	UpdatedAt      time.Time `json:"-"`
+	ID             int         `json:"categoryId"`
+	Title          string      `json:"categoryTitle"`
+	ImageURL       string      `json:"imageUrl"`
+	ParentCategory *Category   `json:"parentCategory,omitempty"`
+	Children       []*Category `json:"children,omitempty"`
+	DeletedAt      *time.Time  `json:"deletedAt,omitempty"`
+	CreatedAt      time.Time   `json:"-"`
+	UpdatedAt      time.Time   `json:"-"`
+	// Version goes up with every change to the category. When set on an
+	// update, the update only goes ahead if the category is still at that
+	// version.
+	Version int `json:"-"`
+}
+
+// maxSKULength is the longest SKU a product may have.
+const maxSKULength = 64
+
+// skuPattern is the form of a SKU: letters, digits, dots, dashes and
+// underscores, starting with a letter or digit.
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validateSKU returns what is wrong with sku, or "" if nothing is.
+func validateSKU(sku string) string {
+	switch {
+	case sku == "":
+		return "must not be empty"
+	case len(sku) > maxSKULength:
+		return fmt.Sprintf("must be at most %d characters", maxSKULength)
+	case !skuPattern.MatchString(sku):
+		return "must contain only letters, digits, '.', '-' and '_', starting with a letter or digit"
+	}
+	return ""
+}
+
+// Validate checks the fields of a new product that a client sets. It returns
+// a *ValidationError listing every field at fault.
+func (p Product) Validate() error {
+	v := p.validate()
+	if problem := validateSKU(p.SKU); problem != "" {
+		v.Add("sku", problem)
+	}
+	return v.Err()
+}
+
+// ValidateUpdate checks the fields of a product that a client sets, like
+// Validate, except for the SKU. Update checks that only if it changes, so
+// products saved before SKUs had a set form can still be edited.
+func (p Product) ValidateUpdate() error {
+	v := p.validate()
+	return v.Err()
+}
+
+// validate checks the fields of a product other than its SKU.
+func (p Product) validate() *ValidationError {
+	var v ValidationError
+
+	if strings.TrimSpace(p.Title) == "" {
+		v.Add("productTitle", "must not be empty")
+	}
+	if p.PriceUnit.IsNegative() {
+		v.Add("priceUnit", "must not be negative")
+	}
+	if p.Quantity < 0 {
+		v.Add("quantity", "must not be negative")
+	}
+	if p.LowStockThreshold != nil && *p.LowStockThreshold < 0 {
+		v.Add("lowStockThreshold", "must not be negative")
+	}
+
+	return &v
+}
+
+// Validate checks the fields of a category that a client sets. It returns a
+// *ValidationError listing every field at fault.
+func (c Category) Validate() error {
+	var v ValidationError
+
+	if strings.TrimSpace(c.Title) == "" {
+		v.Add("categoryTitle", "must not be empty")
+	}
+
+	return v.Err()
 }
 
 type ProductModel struct {
@@ -51,95 +172,136 @@ type CategoryModel struct {
 
 // Product methods
 
-func (m *ProductModel) GetAll() ([]*Product, error) {
+// GetAll returns one page of products matching filter, along with the total
+// number of matching products.
+func (m *ProductModel) GetAll(filter ProductFilter, opts ListOptions) ([]*Product, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	var args queryArgs
+	where := filter.where(&args)
+
+	countQuery := `SELECT count(*) FROM products p ` + where
+
+	var total int
+	err := m.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	query := `
-		SELECT p.product_id, p.product_title, p.image_url, p.sku, p.price_unit, p.quantity, p.created_at, p.updated_at,
-		       c.category_id, c.category_title, c.image_url
+		SELECT ` + productColumns + `
 		FROM products p
 		LEFT JOIN categories c ON p.category_id = c.category_id
-	`
+		` + where + `
+		` + opts.orderBy() + `
+		LIMIT ` + args.add(opts.Size) + ` OFFSET ` + args.add(opts.offset())
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var products []*Product
+	products := []*Product{}
 
 	for rows.Next() {
-		var p Product
-		var c Category
-		var cID sql.NullInt32
-		var cTitle sql.NullString
-		var cImage sql.NullString
-
-		err := rows.Scan(
-			&p.ID,
-			&p.Title,
-			&p.ImageURL,
-			&p.SKU,
-			&p.PriceUnit,
-			&p.Quantity,
-			&p.CreatedAt,
-			&p.UpdatedAt,
-			&cID,
-			&cTitle,
-			&cImage,
-		)
+		p, err := scanProduct(rows)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
-		if cID.Valid {
-			c.ID = int(cID.Int32)
-			c.Title = cTitle.String
-			c.ImageURL = cImage.String
-			p.Category = &c
-		}
+		products = append(products, p)
+	}
 
-		products = append(products, &p)
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
 	}
 
-	return products, nil
+	return products, total, nil
 }
 
-func (m *ProductModel) GetOne(id int) (*Product, error) {
+// GetOne returns the product with the given id, with its options and
+// variants. An archived product is only returned with includeDeleted.
+func (m *ProductModel) GetOne(id int, includeDeleted bool) (*Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	query := `
-		SELECT p.product_id, p.product_title, p.image_url, p.sku, p.price_unit, p.quantity, p.created_at, p.updated_at,
-		       c.category_id, c.category_title, c.image_url
+		SELECT ` + productColumns + `
 		FROM products p
 		LEFT JOIN categories c ON p.category_id = c.category_id
-		WHERE p.product_id = $1
+		WHERE p.product_id = $1 AND (p.deleted_at IS NULL OR $2)
 	`
 
+	row := m.DB.QueryRowContext(ctx, query, id, includeDeleted)
+	p, err := scanProduct(row)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Options, err = getOptions(ctx, m.DB, id)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Variants, err = getVariants(ctx, m.DB, `v.product_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// productColumns is the select list read by scanProduct. Queries using it
+// must alias products as p and join categories as c.
+const productColumns = `p.product_id, p.product_title, p.image_url, p.sku, p.price_unit::text || ' ' || p.currency, p.quantity, p.reserved, p.low_stock_threshold, p.attributes, p.status, p.publish_at, p.unpublish_at, p.deleted_at, p.created_at, p.updated_at, p.version,
+		       c.category_id, c.category_title, c.image_url, c.version`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanProduct scans a row selected with productColumns. Any extra
+// destinations are scanned from the columns that follow productColumns.
+func scanProduct(row rowScanner, extra ...any) (*Product, error) {
 	var p Product
 	var c Category
 	var cID sql.NullInt32
 	var cTitle sql.NullString
 	var cImage sql.NullString
+	var cVersion sql.NullInt32
+	var attributes []byte
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
+	dest := []any{
 		&p.ID,
 		&p.Title,
 		&p.ImageURL,
 		&p.SKU,
 		&p.PriceUnit,
 		&p.Quantity,
+		&p.Reserved,
+		&p.LowStockThreshold,
+		&attributes,
+		&p.Status,
+		&p.PublishAt,
+		&p.UnpublishAt,
+		&p.DeletedAt,
 		&p.CreatedAt,
 		&p.UpdatedAt,
+		&p.Version,
 		&cID,
 		&cTitle,
 		&cImage,
-	)
+		&cVersion,
+	}
 
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(attributes, &p.Attributes)
 	if err != nil {
 		return nil, err
 	}
@@ -148,34 +310,82 @@ func (m *ProductModel) GetOne(id int) (*Product, error) {
 		c.ID = int(cID.Int32)
 		c.Title = cTitle.String
 		c.ImageURL = cImage.String
+		c.Version = int(cVersion.Int32)
 		p.Category = &c
 	}
 
+	p.Available = p.Quantity - p.Reserved
+
 	return &p, nil
 }
 
+// Insert creates product. Its starting quantity is recorded in the
+// inventory ledger, and its price in the price history.
 func (m *ProductModel) Insert(product Product) (*Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	saved, err := insertProduct(ctx, tx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// insertProduct is Insert within an existing transaction.
+func insertProduct(ctx context.Context, tx *sql.Tx, product Product) (*Product, error) {
+	err := normalizePrice(&product.PriceUnit, DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+
 	var categoryID *int
 	if product.Category != nil {
 		categoryID = &product.Category.ID
+
+		err = checkCategoryLive(ctx, tx, *categoryID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	attributes, err := validateAttributes(ctx, tx, categoryID, product.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	// New products start as drafts, out of public view until published
+	product.Status = ProductDraft
+	product.PublishAt, product.UnpublishAt = nil, nil
+
 	query := `
-		INSERT INTO products (product_title, image_url, sku, price_unit, quantity, category_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO products (product_title, image_url, sku, price_unit, currency, quantity, low_stock_threshold, attributes, category_id, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, 0, $6, $7, $8, $9, $10, $11)
 		RETURNING product_id
 	`
 
-	err := m.DB.QueryRowContext(ctx, query,
+	err = tx.QueryRowContext(ctx, query,
 		product.Title,
 		product.ImageURL,
 		product.SKU,
 		product.PriceUnit,
-		product.Quantity,
+		product.PriceUnit.Currency,
+		product.LowStockThreshold,
+		attributes,
 		categoryID,
+		product.Status,
 		time.Now(),
 		time.Now(),
 	).Scan(&product.ID)
@@ -184,30 +394,121 @@ func (m *ProductModel) Insert(product Product) (*Product, error) {
 		return nil, err
 	}
 
+	err = recordPrice(ctx, tx, product.ID, product.PriceUnit, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = adjustQuantity(ctx, tx, product.ID, product.Quantity, ReasonRestock)
+	if err != nil {
+		return nil, err
+	}
+
+	// A product created with no stock at all still needs checking against its threshold
+	err = checkLowStock(ctx, tx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	product.Reserved = 0
+	product.Available = product.Quantity
+
+	err = enqueueCatalogEvent(ctx, tx, EventProductCreated, AggregateProduct, product.ID, product)
+	if err != nil {
+		return nil, err
+	}
+
 	return &product, nil
 }
 
+// Update saves product. A change of quantity is recorded in the inventory
+// ledger as an adjustment, and a change of price in the price history.
 func (m *ProductModel) Update(product Product) (*Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	saved, err := updateProduct(ctx, tx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// updateProduct is Update within an existing transaction.
+func updateProduct(ctx context.Context, tx *sql.Tx, product Product) (*Product, error) {
+	var err error
 	var categoryID *int
 	if product.Category != nil {
 		categoryID = &product.Category.ID
+
+		err = checkCategoryLive(ctx, tx, *categoryID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var current, version int
+	var currentPrice Money
+	var currentSKU string
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(quantity, 0), reserved, price_unit::text || ' ' || currency, COALESCE(sku, ''), version, status, publish_at, unpublish_at FROM products WHERE product_id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		product.ID,
+	).Scan(&current, &product.Reserved, &currentPrice, &currentSKU, &version, &product.Status, &product.PublishAt, &product.UnpublishAt)
+	if err != nil {
+		return nil, err
+	}
+	if product.Version != 0 && product.Version != version {
+		return nil, fmt.Errorf("product %d %w", product.ID, ErrVersionMismatch)
+	}
+
+	// A SKU from before SKUs had a set form may be kept, but not given
+	if product.SKU != currentSKU {
+		if problem := validateSKU(product.SKU); problem != "" {
+			var v ValidationError
+			v.Add("sku", problem)
+			return nil, v.Err()
+		}
+	}
+
+	// A price that names no currency keeps the product's
+	err = normalizePrice(&product.PriceUnit, currentPrice.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := validateAttributes(ctx, tx, categoryID, product.Attributes)
+	if err != nil {
+		return nil, err
 	}
 
 	query := `
 		UPDATE products
-		SET product_title = $1, image_url = $2, sku = $3, price_unit = $4, quantity = $5, category_id = $6, updated_at = $7
-		WHERE product_id = $8
+		SET product_title = $1, image_url = $2, sku = $3, price_unit = $4, currency = $5, low_stock_threshold = $6, attributes = $7, category_id = $8, updated_at = $9
+		WHERE product_id = $10
 	`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	// A product without a SKU keeps it NULL, which the unique index lets
+	// any number of products share
+	_, err = tx.ExecContext(ctx, query,
 		product.Title,
 		product.ImageURL,
-		product.SKU,
+		sql.NullString{String: product.SKU, Valid: product.SKU != ""},
 		product.PriceUnit,
-		product.Quantity,
+		product.PriceUnit.Currency,
+		product.LowStockThreshold,
+		attributes,
 		categoryID,
 		time.Now(),
 		product.ID,
@@ -217,30 +518,123 @@ func (m *ProductModel) Update(product Product) (*Product, error) {
 		return nil, err
 	}
 
+	// Sending back the price a scheduled change put in place leaves it running
+	if product.PriceUnit != currentPrice {
+		err = recordPrice(ctx, tx, product.ID, product.PriceUnit, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The quantity change is recorded after the new threshold is saved, so a
+	// change to either one can raise the alert
+	err = adjustQuantity(ctx, tx, product.ID, product.Quantity-current, ReasonAdjustment)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLowStock(ctx, tx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	product.Available = product.Quantity - product.Reserved
+
+	err = scanVersions(ctx, tx, &product)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventProductUpdated, AggregateProduct, product.ID, product)
+	if err != nil {
+		return nil, err
+	}
+
 	return &product, nil
 }
 
+// scanVersions reads the current versions of product and its category, which
+// the statements saving it have moved on.
+func scanVersions(ctx context.Context, q queryer, product *Product) error {
+	var categoryVersion sql.NullInt32
+	err := q.QueryRowContext(ctx, `
+		SELECT p.version, c.version
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.category_id
+		WHERE p.product_id = $1
+	`, product.ID).Scan(&product.Version, &categoryVersion)
+	if err != nil {
+		return err
+	}
+
+	if product.Category != nil {
+		product.Category.Version = int(categoryVersion.Int32)
+	}
+
+	return nil
+}
+
+// adjustQuantity records a system movement of change for the product, if
+// change is non-zero. Decreases are always recorded as adjustments.
+func adjustQuantity(ctx context.Context, tx *sql.Tx, productID, change int, reason string) error {
+	if change == 0 {
+		return nil
+	}
+	if change < 0 {
+		reason = ReasonAdjustment
+	}
+
+	_, err := recordMovement(ctx, tx, InventoryMovement{
+		ProductID: productID,
+		Change:    change,
+		Reason:    reason,
+		Actor:     SystemActor,
+	})
+	return err
+}
+
+// Delete archives the product with the given id. It is hidden from the
+// catalog but kept, so that orders referencing it still resolve, until
+// Purge removes it. It returns sql.ErrNoRows if there is no such product
+// that is not already archived.
 func (m *ProductModel) Delete(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `DELETE FROM products WHERE product_id = $1`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
-	return err
+	query := `UPDATE products SET deleted_at = $1 WHERE product_id = $2 AND deleted_at IS NULL RETURNING product_id`
+
+	err = tx.QueryRowContext(ctx, query, time.Now(), id).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventProductDeleted, AggregateProduct, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Category methods
 
-func (m *CategoryModel) GetAll() ([]*Category, error) {
+// GetAll returns every category with ParentCategory populated all the way
+// up to the root, as GetOne does. Archived categories are only returned with
+// includeDeleted, but still appear as the parents of those that are not.
+func (m *CategoryModel) GetAll(includeDeleted bool) ([]*Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	query := `
-		SELECT c.category_id, c.category_title, c.image_url, c.created_at, c.updated_at,
-		       p.category_id, p.category_title, p.image_url
-		FROM categories c
-		LEFT JOIN categories p ON c.parent_category_id = p.category_id
+		SELECT category_id, category_title, image_url, deleted_at, created_at, updated_at, parent_category_id
+		FROM categories
+		ORDER BY category_id
 	`
 
 	rows, err := m.DB.QueryContext(ctx, query)
@@ -249,84 +643,68 @@ func (m *CategoryModel) GetAll() ([]*Category, error) {
 	}
 	defer rows.Close()
 
-	var categories []*Category
+	var all []*Category
+	byID := map[int]*Category{}
+	parentIDs := map[int]int{}
 
 	for rows.Next() {
 		var c Category
-		var p Category
-		var pID sql.NullInt32
-		var pTitle sql.NullString
-		var pImage sql.NullString
+		var parentID sql.NullInt32
 
 		err := rows.Scan(
 			&c.ID,
 			&c.Title,
 			&c.ImageURL,
+			&c.DeletedAt,
 			&c.CreatedAt,
 			&c.UpdatedAt,
-			&pID,
-			&pTitle,
-			&pImage,
+			&parentID,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		if pID.Valid {
-			p.ID = int(pID.Int32)
-			p.Title = pTitle.String
-			p.ImageURL = pImage.String
-			c.ParentCategory = &p
+		if parentID.Valid {
+			parentIDs[c.ID] = int(parentID.Int32)
 		}
 
-		categories = append(categories, &c)
+		all = append(all, &c)
+		byID[c.ID] = &c
 	}
 
-	return categories, nil
-}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-func (m *CategoryModel) GetOne(id int) (*Category, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+	// Categories share their parents, so each chain is linked once
+	for id, parentID := range parentIDs {
+		byID[id].ParentCategory = byID[parentID]
+	}
 
-	query := `
-		SELECT c.category_id, c.category_title, c.image_url, c.created_at, c.updated_at,
-		       p.category_id, p.category_title, p.image_url
-		FROM categories c
-		LEFT JOIN categories p ON c.parent_category_id = p.category_id
-		WHERE c.category_id = $1
-	`
+	var categories []*Category
+	for _, c := range all {
+		if c.DeletedAt == nil || includeDeleted {
+			categories = append(categories, c)
+		}
+	}
 
-	var c Category
-	var p Category
-	var pID sql.NullInt32
-	var pTitle sql.NullString
-	var pImage sql.NullString
-
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&c.ID,
-		&c.Title,
-		&c.ImageURL,
-		&c.CreatedAt,
-		&c.UpdatedAt,
-		&pID,
-		&pTitle,
-		&pImage,
-	)
+	return categories, nil
+}
 
+// GetOne returns the category with the given id, with ParentCategory
+// populated all the way up to the root. An archived category is only
+// returned with includeDeleted.
+func (m *CategoryModel) GetOne(id int, includeDeleted bool) (*Category, error) {
+	path, err := m.breadcrumbs(id, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
 
-	if pID.Valid {
-		p.ID = int(pID.Int32)
-		p.Title = pTitle.String
-		p.ImageURL = pImage.String
-		c.ParentCategory = &p
+	for i := 1; i < len(path); i++ {
+		path[i].ParentCategory = path[i-1]
 	}
 
-	return &c, nil
+	return path[len(path)-1], nil
 }
 
 func (m *CategoryModel) Insert(category Category) (*Category, error) {
@@ -338,13 +716,26 @@ func (m *CategoryModel) Insert(category Category) (*Category, error) {
 		parentID = &category.ParentCategory.ID
 	}
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if parentID != nil {
+		err = checkCategoryLive(ctx, tx, *parentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	query := `
 		INSERT INTO categories (category_title, image_url, parent_category_id, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5)
 		RETURNING category_id
 	`
 
-	err := m.DB.QueryRowContext(ctx, query,
+	err = tx.QueryRowContext(ctx, query,
 		category.Title,
 		category.ImageURL,
 		parentID,
@@ -356,9 +747,21 @@ func (m *CategoryModel) Insert(category Category) (*Category, error) {
 		return nil, err
 	}
 
+	err = enqueueCatalogEvent(ctx, tx, EventCategoryCreated, AggregateCategory, category.ID, category)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
 	return &category, nil
 }
 
+// Update saves category. It returns ErrCategoryCycle if the new parent is
+// the category itself or one of its descendants.
 func (m *CategoryModel) Update(category Category) (*Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -368,33 +771,51 @@ func (m *CategoryModel) Update(category Category) (*Category, error) {
 		parentID = &category.ParentCategory.ID
 	}
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockCategoryTree(ctx, tx, category.ID, parentID)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		UPDATE categories
 		SET category_title = $1, image_url = $2, parent_category_id = $3, updated_at = $4
-		WHERE category_id = $5
+		WHERE category_id = $5 AND ($6 = 0 OR version = $6)
+		RETURNING version
 	`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	err = tx.QueryRowContext(ctx, query,
 		category.Title,
 		category.ImageURL,
 		parentID,
 		time.Now(),
 		category.ID,
-	)
+		category.Version,
+	).Scan(&category.Version)
 
+	// lockCategoryTree has found the category, so it can only be missed
+	// because of its version
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("category %d %w", category.ID, ErrVersionMismatch)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &category, nil
-}
-
-func (m *CategoryModel) Delete(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+	err = enqueueCatalogEvent(ctx, tx, EventCategoryUpdated, AggregateCategory, category.ID, category)
+	if err != nil {
+		return nil, err
+	}
 
-	query := `DELETE FROM categories WHERE category_id = $1`
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, id)
-	return err
+	return &category, nil
 }
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProductValidate(t *testing.T) {
+	threshold := -1
+
+	tests := []struct {
+		name       string
+		product    Product
+		wantFields []string
+	}{
+		{"valid", Product{Title: "T-shirt", SKU: "TSHIRT-RED", PriceUnit: Money{1999, "USD"}}, nil},
+		{"no title", Product{Title: " ", SKU: "TSHIRT-RED"}, []string{"productTitle"}},
+		{"no sku", Product{Title: "T-shirt"}, []string{"sku"}},
+		{"sku with spaces", Product{Title: "T-shirt", SKU: "T SHIRT"}, []string{"sku"}},
+		{"sku starting with a dash", Product{Title: "T-shirt", SKU: "-TSHIRT"}, []string{"sku"}},
+		{"sku too long", Product{Title: "T-shirt", SKU: string(make([]byte, maxSKULength+1))}, []string{"sku"}},
+		{"negative numbers", Product{Title: "T-shirt", SKU: "TSHIRT-RED", PriceUnit: Money{-1, "USD"}, Quantity: -1, LowStockThreshold: &threshold}, []string{"priceUnit", "quantity", "lowStockThreshold"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFields(t, tt.product.Validate(), tt.wantFields)
+		})
+	}
+}
+
+func TestProductValidateUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		product    Product
+		wantFields []string
+	}{
+		{"legacy sku", Product{Title: "T-shirt", SKU: "T SHIRT / red"}, nil},
+		{"no sku", Product{Title: "T-shirt"}, nil},
+		{"no title", Product{SKU: "TSHIRT-RED"}, []string{"productTitle"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFields(t, tt.product.ValidateUpdate(), tt.wantFields)
+		})
+	}
+}
+
+// checkFields checks that err is a *ValidationError naming exactly fields,
+// or nil if there are none.
+func checkFields(t *testing.T, err error, fields []string) {
+	t.Helper()
+
+	if len(fields) == 0 {
+		if err != nil {
+			t.Fatalf("error = %v, want nil", err)
+		}
+		return
+	}
+
+	var v *ValidationError
+	if !errors.As(err, &v) {
+		t.Fatalf("error = %v, want a *ValidationError", err)
+	}
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("error = %v, want it to wrap ErrInvalid", err)
+	}
+	if len(v.Fields) != len(fields) {
+		t.Errorf("fields = %v, want %v", v.Fields, fields)
+	}
+	for _, f := range fields {
+		if _, ok := v.Fields[f]; !ok {
+			t.Errorf("fields = %v, want %s among them", v.Fields, f)
+		}
+	}
+}
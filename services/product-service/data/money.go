@@ -0,0 +1,369 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of prices that do not name one.
+const DefaultCurrency = "USD"
+
+var (
+	// ErrCurrencyMismatch is returned by arithmetic on amounts in different
+	// currencies.
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+
+	// ErrMoneyOverflow is returned when a result does not fit in Money.
+	ErrMoneyOverflow = errors.New("amount out of range")
+)
+
+var currencyRX = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// zeroDecimalCurrencies have no minor unit. Every other currency is taken to
+// have two decimal places, the most a DECIMAL(10, 2) price column holds.
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "ISK": true,
+	"JPY": true, "KMF": true, "KRW": true, "PYG": true, "RWF": true,
+	"UGX": true, "VND": true, "VUV": true, "XAF": true, "XOF": true,
+	"XPF": true,
+}
+
+// Money is an exact amount of a currency, counted in its minor unit (cents
+// for USD). It is written to DECIMAL columns as a decimal string and
+// serialized to JSON as {"amount": "45.99", "currency": "USD"}.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// decimals returns the number of decimal places of currency.
+func decimals(currency string) int {
+	if zeroDecimalCurrencies[currency] {
+		return 0
+	}
+	return 2
+}
+
+// ValidateCurrency checks that currency is a three letter ISO 4217 code.
+func ValidateCurrency(currency string) error {
+	if !currencyRX.MatchString(currency) {
+		return fmt.Errorf("currency %q must be a three letter ISO 4217 code", currency)
+	}
+	return nil
+}
+
+// normalizePrice reads a price that names no currency as an amount of
+// currency, and checks that it is a valid price. Pass the product's stored
+// currency when updating it, and DefaultCurrency when creating it.
+func normalizePrice(price *Money, currency string) error {
+	if price.Currency == "" {
+		p, err := price.inCurrency(currency)
+		if err != nil {
+			return err
+		}
+		*price = p
+	}
+	if price.IsNegative() {
+		return errors.New("price must not be negative")
+	}
+	return ValidateCurrency(price.Currency)
+}
+
+// inCurrency returns an amount read without a currency, such as a bare JSON
+// number, as an amount of currency.
+func (m Money) inCurrency(currency string) (Money, error) {
+	return ParseMoney(m.Decimal(), currency)
+}
+
+// ParseMoney parses a decimal amount such as "45.99" or "-3" in currency.
+// Digits beyond the currency's minor unit are only accepted if they are
+// zeros, so no amount is ever rounded.
+func ParseMoney(s, currency string) (Money, error) {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	digits := decimals(currency)
+
+	text := strings.TrimSpace(s)
+	neg := false
+	if rest, ok := strings.CutPrefix(text, "-"); ok {
+		neg, text = true, rest
+	} else if rest, ok := strings.CutPrefix(text, "+"); ok {
+		text = rest
+	}
+
+	whole, frac, _ := strings.Cut(text, ".")
+	if whole == "" && frac == "" || !allDigits(whole) || !allDigits(frac) {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	if len(frac) > digits {
+		if strings.Trim(frac[digits:], "0") != "" {
+			return Money{}, fmt.Errorf("amount %q has more decimal places than %s allows", s, currency)
+		}
+		frac = frac[:digits]
+	}
+	frac += strings.Repeat("0", digits-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrMoneyOverflow, s)
+	}
+	if neg {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decimal formats the amount without its currency, such as "45.99".
+func (m Money) Decimal() string {
+	digits := decimals(m.Currency)
+
+	abs := uint64(m.Amount)
+	sign := ""
+	if m.Amount < 0 {
+		abs = uint64(-(m.Amount + 1)) + 1
+		sign = "-"
+	}
+
+	s := strconv.FormatUint(abs, 10)
+	if digits == 0 {
+		return sign + s
+	}
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Cmp compares m with o, returning -1, 0 or +1.
+func (m Money) Cmp(o Money) (int, error) {
+	if m.Currency != o.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(Money{Amount: -o.Amount, Currency: o.Currency})
+}
+
+// Mul multiplies the amount by n, such as a unit price by a quantity.
+func (m Money) Mul(n int64) (Money, error) {
+	return m.MulRatio(n, 1)
+}
+
+// MulRatio multiplies the amount by num/den, rounding half to even to the
+// minor unit. A 20% tax on m is m.MulRatio(20, 100), and a 15% discount
+// leaves m.MulRatio(85, 100).
+func (m Money) MulRatio(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, errors.New("ratio denominator must not be zero")
+	}
+
+	r := new(big.Rat).SetFrac(
+		new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(num)),
+		big.NewInt(den),
+	)
+
+	amount := roundHalfEven(r)
+	if !amount.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	return Money{Amount: amount.Int64(), Currency: m.Currency}, nil
+}
+
+// Convert converts m into currency at rate, the number of units of currency
+// one unit of m's currency buys, rounding half to even to the minor unit.
+func (m Money) Convert(currency string, rate *big.Rat) (Money, error) {
+	if rate.Sign() <= 0 {
+		return Money{}, errors.New("exchange rate must be positive")
+	}
+
+	// Scale for the difference in minor units, e.g. cents to whole yen
+	scale := new(big.Rat).SetFrac(
+		new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals(currency))), nil),
+		new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals(m.Currency))), nil),
+	)
+
+	r := new(big.Rat).SetInt64(m.Amount)
+	r.Mul(r, rate)
+	r.Mul(r, scale)
+
+	amount := roundHalfEven(r)
+	if !amount.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	return Money{Amount: amount.Int64(), Currency: currency}, nil
+}
+
+func roundHalfEven(r *big.Rat) *big.Int {
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+
+	// Compare twice the remainder with the denominator to find the nearest integer
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+
+	switch twice.Cmp(r.Denom()) {
+	case 1:
+		q.Add(q, big.NewInt(int64(r.Sign())))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(int64(r.Sign())))
+		}
+	}
+
+	return q
+}
+
+// Value writes the amount to a DECIMAL column.
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
+
+// Scan reads a DECIMAL column. The column may be selected together with its
+// currency as "45.99 USD", as in
+//
+//	p.price_unit::text || ' ' || p.currency
+//
+// otherwise m.Currency, or DefaultCurrency, is kept. NULL scans as zero.
+func (m *Money) Scan(src any) error {
+	var s string
+
+	switch v := src.(type) {
+	case nil:
+		m.Amount = 0
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+
+	currency := m.Currency
+	if amount, code, ok := strings.Cut(s, " "); ok {
+		s, currency = amount, code
+	}
+
+	parsed, err := ParseMoney(s, currency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+// UnmarshalJSON reads {"amount": "45.99", "currency": "USD"}, where amount
+// may also be a JSON number. A bare number or numeric string is read as an
+// amount with no currency, for clients that send prices as plain numbers;
+// the currency is then filled in by whatever saves it.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	var amount json.Number
+	var currency string
+
+	if len(b) > 0 && b[0] == '{' {
+		var v struct {
+			Amount   json.Number `json:"amount"`
+			Currency string      `json:"currency"`
+		}
+		err := json.Unmarshal(b, &v)
+		if err != nil {
+			return err
+		}
+		amount, currency = v.Amount, v.Currency
+	} else {
+		err := json.Unmarshal(b, &amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	if currency != "" {
+		err := ValidateCurrency(currency)
+		if err != nil {
+			return err
+		}
+	}
+
+	parsed, err := ParseMoney(amount.String(), currency)
+	if err != nil {
+		return err
+	}
+
+	parsed.Currency = currency
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		currency string
+		want     Money
+		wantErr  bool
+	}{
+		{"cents", "45.99", "USD", Money{4599, "USD"}, false},
+		{"whole", "45", "USD", Money{4500, "USD"}, false},
+		{"one decimal", "45.5", "EUR", Money{4550, "EUR"}, false},
+		{"no whole part", ".5", "USD", Money{50, "USD"}, false},
+		{"trailing zeros", "45.9900", "USD", Money{4599, "USD"}, false},
+		{"negative", "-3", "USD", Money{-300, "USD"}, false},
+		{"plus sign", "+3.10", "USD", Money{310, "USD"}, false},
+		{"spaces", " 1.00 ", "USD", Money{100, "USD"}, false},
+		{"zero decimal currency", "1500", "JPY", Money{1500, "JPY"}, false},
+		{"zero decimal currency with zeros", "1500.00", "JPY", Money{1500, "JPY"}, false},
+		{"no currency", "1.25", "", Money{125, DefaultCurrency}, false},
+		{"too many decimals", "45.999", "USD", Money{}, true},
+		{"decimals in zero decimal currency", "1500.5", "JPY", Money{}, true},
+		{"empty", "", "USD", Money{}, true},
+		{"only a point", ".", "USD", Money{}, true},
+		{"letters", "12a", "USD", Money{}, true},
+		{"two signs", "--1", "USD", Money{}, true},
+		{"exponent", "1e3", "USD", Money{}, true},
+		{"overflow", "99999999999999999999", "USD", Money{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.s, tt.currency)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMoney(%q, %q) error = %v, wantErr %v", tt.s, tt.currency, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q, %q) = %v, want %v", tt.s, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{4599, "USD"}, "45.99"},
+		{Money{5, "USD"}, "0.05"},
+		{Money{0, "USD"}, "0.00"},
+		{Money{-5, "USD"}, "-0.05"},
+		{Money{1500, "JPY"}, "1500"},
+		{Money{-9223372036854775808, "USD"}, "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.m.Decimal(); got != tt.want {
+				t.Errorf("Decimal() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Money
+		wantErr bool
+	}{
+		{"object", `{"amount": "45.99", "currency": "EUR"}`, Money{4599, "EUR"}, false},
+		{"object with number", `{"amount": 45.99, "currency": "EUR"}`, Money{4599, "EUR"}, false},
+		{"object without currency", `{"amount": "45.99"}`, Money{4599, ""}, false},
+		{"bare number", `45.99`, Money{4599, ""}, false},
+		{"bare string", `"45.99"`, Money{4599, ""}, false},
+		{"bad currency", `{"amount": "1", "currency": "euro"}`, Money{}, true},
+		{"bad amount", `{"amount": "abc", "currency": "EUR"}`, Money{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.json, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.json, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		price    Money
+		currency string
+		want     Money
+		wantErr  bool
+	}{
+		{"keeps its own currency", Money{4599, "GBP"}, "EUR", Money{4599, "GBP"}, false},
+		{"takes the stored currency", Money{4599, ""}, "EUR", Money{4599, "EUR"}, false},
+		{"takes the default on create", Money{4599, ""}, DefaultCurrency, Money{4599, "USD"}, false},
+		{"rescales to a zero decimal currency", Money{150000, ""}, "JPY", Money{1500, "JPY"}, false},
+		{"negative", Money{-1, "EUR"}, "EUR", Money{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.price
+			err := normalizePrice(&got, tt.currency)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizePrice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("normalizePrice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	usd := func(a int64) Money { return Money{a, "USD"} }
+
+	tests := []struct {
+		name    string
+		op      func() (Money, error)
+		want    Money
+		wantErr error
+	}{
+		{"add", func() (Money, error) { return usd(150).Add(usd(250)) }, usd(400), nil},
+		{"add currencies", func() (Money, error) { return usd(150).Add(Money{1, "EUR"}) }, Money{}, ErrCurrencyMismatch},
+		{"add overflow", func() (Money, error) { return usd(1<<63 - 1).Add(usd(1)) }, Money{}, ErrMoneyOverflow},
+		{"sub", func() (Money, error) { return usd(150).Sub(usd(250)) }, usd(-100), nil},
+		{"mul", func() (Money, error) { return usd(4599).Mul(3) }, usd(13797), nil},
+		{"ratio rounds half to even down", func() (Money, error) { return usd(25).MulRatio(1, 10) }, usd(2), nil},
+		{"ratio rounds half to even up", func() (Money, error) { return usd(35).MulRatio(1, 10) }, usd(4), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
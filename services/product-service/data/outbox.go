@@ -0,0 +1,140 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// OutboxMessage is an event waiting in the outbox to be published.
+type OutboxMessage struct {
+	ID            int64
+	AggregateType string
+	AggregateID   string
+	Destination   string
+	Payload       []byte
+	Attempts      int
+	CreatedAt     time.Time
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// enqueue writes event to the outbox within tx, so that it is published if
+// and only if tx commits. Events for the same aggregate are published in the
+// order they were enqueued.
+func enqueue(ctx context.Context, tx *sql.Tx, aggregateType, aggregateID, destination string, event any) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO outbox (aggregate_type, aggregate_id, destination, payload, created_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+	`
+
+	_, err = tx.ExecContext(ctx, query, aggregateType, aggregateID, destination, payload, time.Now())
+	return err
+}
+
+// Claim hands out up to limit due messages, oldest first, and leases them
+// until lease has passed, when they are due again unless marked published or
+// failed first. Only the oldest unpublished message of each aggregate is
+// ever handed out, so a message that keeps failing holds back the ones
+// behind it rather than being overtaken. Claiming is one short statement;
+// the caller publishes the messages after it returns.
+func (m *OutboxModel) Claim(limit int, lease time.Duration) ([]OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	// SKIP LOCKED lets several relays share the outbox, and the NOT EXISTS
+	// still sees a leased head so nobody can jump ahead of it
+	query := `
+		WITH due AS (
+			SELECT o.outbox_id
+			FROM outbox o
+			WHERE o.published_at IS NULL
+			  AND o.next_attempt_at <= $1
+			  AND NOT EXISTS (
+				SELECT 1 FROM outbox e
+				WHERE e.published_at IS NULL
+				  AND e.aggregate_type = o.aggregate_type
+				  AND e.aggregate_id = o.aggregate_id
+				  AND e.outbox_id < o.outbox_id
+			  )
+			ORDER BY o.outbox_id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE outbox o
+		SET next_attempt_at = $3
+		FROM due
+		WHERE o.outbox_id = due.outbox_id
+		RETURNING o.outbox_id, o.aggregate_type, o.aggregate_id, o.destination, o.payload, o.attempts, o.created_at
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, now, limit, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+
+	for rows.Next() {
+		var msg OutboxMessage
+		err := rows.Scan(
+			&msg.ID,
+			&msg.AggregateType,
+			&msg.AggregateID,
+			&msg.Destination,
+			&msg.Payload,
+			&msg.Attempts,
+			&msg.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the order of the claim
+	slices.SortFunc(messages, func(a, b OutboxMessage) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return messages, nil
+}
+
+// MarkPublished records that the message with the given id was published.
+func (m *OutboxModel) MarkPublished(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE outbox SET published_at = $1 WHERE outbox_id = $2`, time.Now(), id)
+	return err
+}
+
+// MarkFailed records a failed attempt to publish the message with the given
+// id, which is due again after delay.
+func (m *OutboxModel) MarkFailed(id int64, delay time.Duration, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx,
+		`UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $1, last_error = $2 WHERE outbox_id = $3`,
+		time.Now().Add(delay), cause.Error(), id,
+	)
+	return err
+}
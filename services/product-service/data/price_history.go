@@ -0,0 +1,486 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Statuses of a price in the price history.
+const (
+	PricePending   = "pending"
+	PriceActive    = "active"
+	PriceEnded     = "ended"
+	PriceCancelled = "cancelled"
+)
+
+var (
+	// ErrPriceScheduleConflict is returned when a scheduled price change
+	// overlaps another one of the same product.
+	ErrPriceScheduleConflict = errors.New("price change overlaps another scheduled change")
+
+	// ErrPriceChangeEnded is returned when cancelling a price change that has
+	// already ended or been cancelled.
+	ErrPriceChangeEnded = errors.New("price change has already ended")
+)
+
+// PriceChange is one period of a product's price history. The product's
+// regular price is the active change with no EffectiveTo; an active change
+// with an EffectiveTo, such as a sale, overrides it until then.
+type PriceChange struct {
+	ID            int64      `json:"priceChangeId"`
+	ProductID     int        `json:"productId"`
+	Price         Money      `json:"price"`
+	EffectiveFrom time.Time  `json:"effectiveFrom"`
+	EffectiveTo   *time.Time `json:"effectiveTo"`
+	Scheduled     bool       `json:"scheduled"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// PriceHistory is a product's price changes, newest first, with the lowest
+// price it had since LowestPriceSince.
+type PriceHistory struct {
+	Changes          []*PriceChange `json:"changes"`
+	LowestPrice      *Money         `json:"lowestPrice"`
+	LowestPriceSince time.Time      `json:"lowestPriceSince"`
+}
+
+// SchedulePrice schedules change.Price to take effect for change.ProductID
+// at change.EffectiveFrom. With an EffectiveTo the product reverts to its
+// regular price then; without one the change becomes its regular price.
+// It returns sql.ErrNoRows if the product does not exist or is archived.
+func (m *ProductModel) SchedulePrice(change PriceChange) (*PriceChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	var v ValidationError
+	if !change.EffectiveFrom.After(now) {
+		v.Add("effectiveFrom", "must be in the future")
+	}
+	if change.EffectiveTo != nil && !change.EffectiveTo.After(change.EffectiveFrom) {
+		v.Add("effectiveTo", "must be after effectiveFrom")
+	}
+	if change.Price.IsNegative() {
+		v.Add("price", "must not be negative")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The product lock serializes scheduling with the scheduler applying changes
+	var currency string
+	err = tx.QueryRowContext(ctx, `SELECT currency FROM products WHERE product_id = $1 AND deleted_at IS NULL FOR UPDATE`, change.ProductID).Scan(&currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if change.Price.Currency == "" {
+		change.Price, err = change.Price.inCurrency(currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if change.Price.Currency != currency {
+		v.Add("price", fmt.Sprintf("must be in the product's currency %s", currency))
+		return nil, v.Err()
+	}
+
+	// Two scheduled changes overlap if each starts before the other ends
+	var overlaps bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM product_prices
+			WHERE product_id = $1 AND scheduled AND status IN ($2, $3)
+			  AND effective_from < COALESCE($5::timestamp, 'infinity')
+			  AND COALESCE(effective_to, 'infinity') > $4::timestamp
+		)
+	`, change.ProductID, PricePending, PriceActive, change.EffectiveFrom, change.EffectiveTo).Scan(&overlaps)
+	if err != nil {
+		return nil, err
+	}
+	if overlaps {
+		return nil, ErrPriceScheduleConflict
+	}
+
+	change.Scheduled = true
+	change.Status = PricePending
+	change.CreatedAt = now
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO product_prices (product_id, price, currency, effective_from, effective_to, scheduled, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING price_id
+	`,
+		change.ProductID,
+		change.Price,
+		change.Price.Currency,
+		change.EffectiveFrom,
+		change.EffectiveTo,
+		change.Scheduled,
+		change.Status,
+		change.CreatedAt,
+	).Scan(&change.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+// CancelPriceChange cancels a scheduled price change that has not ended. A
+// change already in effect ends now, and the product reverts to its
+// regular price. It returns sql.ErrNoRows if the product does not exist or
+// is archived.
+func (m *ProductModel) CancelPriceChange(productID int, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockProduct(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	var status string
+	var open bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT status, effective_to IS NULL FROM product_prices WHERE price_id = $1 AND product_id = $2 AND scheduled`,
+		id, productID,
+	).Scan(&status, &open)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	switch {
+	case status == PricePending:
+		_, err = tx.ExecContext(ctx,
+			`UPDATE product_prices SET status = $1, updated_at = $2 WHERE price_id = $3`,
+			PriceCancelled, now, id,
+		)
+	case status == PriceActive && !open:
+		_, err = tx.ExecContext(ctx,
+			`UPDATE product_prices SET status = $1, effective_to = $2, updated_at = $2 WHERE price_id = $3`,
+			PriceEnded, now, id,
+		)
+	default:
+		// An open-ended change that went live is the regular price now
+		return ErrPriceChangeEnded
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = syncPrice(ctx, tx, productID, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PriceHistory returns a product's price changes, including scheduled ones,
+// and the lowest price it had in the last days days.
+func (m *ProductModel) PriceHistory(productID, days int) (*PriceHistory, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE product_id = $1)`, productID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	query := `
+		SELECT price_id, product_id, price::text || ' ' || currency, effective_from, effective_to, scheduled, status, created_at
+		FROM product_prices
+		WHERE product_id = $1
+		ORDER BY effective_from DESC, price_id DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	h := PriceHistory{
+		Changes:          []*PriceChange{},
+		LowestPriceSince: now.AddDate(0, 0, -days),
+	}
+
+	for rows.Next() {
+		var c PriceChange
+		err := rows.Scan(
+			&c.ID,
+			&c.ProductID,
+			&c.Price,
+			&c.EffectiveFrom,
+			&c.EffectiveTo,
+			&c.Scheduled,
+			&c.Status,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		h.Changes = append(h.Changes, &c)
+
+		// Only prices the product actually had in the window count
+		inEffect := c.Status == PriceActive || c.Status == PriceEnded
+		inWindow := c.EffectiveFrom.Before(now) && (c.EffectiveTo == nil || c.EffectiveTo.After(h.LowestPriceSince))
+		if !inEffect || !inWindow {
+			continue
+		}
+
+		if h.LowestPrice == nil {
+			h.LowestPrice = &c.Price
+			continue
+		}
+		if cmp, err := c.Price.Cmp(*h.LowestPrice); err == nil && cmp < 0 {
+			h.LowestPrice = &c.Price
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+// ApplyScheduledPrices starts the scheduled price changes that are due and
+// ends those that have run their course, updating the prices of the
+// products affected. It returns how many products it repriced.
+func (m *ProductModel) ApplyScheduledPrices() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT DISTINCT product_id
+		FROM product_prices
+		WHERE (status = $1 AND effective_from <= $3) OR (status = $2 AND effective_to <= $3)
+	`, PricePending, PriceActive, now)
+	if err != nil {
+		return 0, err
+	}
+
+	var productIDs []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		productIDs = append(productIDs, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range productIDs {
+		changed, err := m.applyScheduledPrices(ctx, id, now)
+		if err != nil {
+			return n, err
+		}
+		if changed {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// applyScheduledPrices moves the due price changes of one product along and
+// reports whether its price changed.
+func (m *ProductModel) applyScheduledPrices(ctx context.Context, productID int, now time.Time) (bool, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT 1 FROM products WHERE product_id = $1 FOR UPDATE`, productID)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE product_prices SET status = $1, updated_at = $2
+		WHERE product_id = $3 AND status = $4 AND effective_to <= $2
+	`, PriceEnded, now, productID, PriceActive)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT price_id, effective_from, effective_to
+		FROM product_prices
+		WHERE product_id = $1 AND status = $2 AND effective_from <= $3
+		ORDER BY effective_from, price_id
+	`, productID, PricePending, now)
+	if err != nil {
+		return false, err
+	}
+
+	type due struct {
+		id   int64
+		from time.Time
+		to   *time.Time
+	}
+
+	var changes []due
+	for rows.Next() {
+		var d due
+		err := rows.Scan(&d.id, &d.from, &d.to)
+		if err != nil {
+			rows.Close()
+			return false, err
+		}
+		changes = append(changes, d)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	for _, d := range changes {
+		status := PriceActive
+
+		switch {
+		case d.to != nil && !d.to.After(now):
+			// Missed entirely, say while the service was down, so it never applied
+			status = PriceCancelled
+		case d.to == nil:
+			// An open-ended change takes over as the regular price
+			_, err = tx.ExecContext(ctx, `
+				UPDATE product_prices SET status = $1, effective_to = $2, updated_at = $3
+				WHERE product_id = $4 AND status = $5 AND effective_to IS NULL
+			`, PriceEnded, d.from, now, productID, PriceActive)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE product_prices SET status = $1, updated_at = $2 WHERE price_id = $3`,
+			status, now, d.id,
+		)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	changed, err := syncPrice(ctx, tx, productID, now)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// syncPrice sets a product's price to what its price history says is in
+// effect: the latest active change with an end, else its regular price. A
+// product with no history keeps the price it has. It reports whether the
+// price changed.
+func syncPrice(ctx context.Context, tx *sql.Tx, productID int, now time.Time) (bool, error) {
+	query := `
+		WITH current AS (
+			SELECT price, currency
+			FROM product_prices
+			WHERE product_id = $1 AND status = $2
+			ORDER BY effective_to IS NULL, effective_from DESC, price_id DESC
+			LIMIT 1
+		)
+		UPDATE products p
+		SET price_unit = c.price, currency = c.currency, updated_at = $3
+		FROM current c
+		WHERE p.product_id = $1 AND (p.price_unit IS DISTINCT FROM c.price OR p.currency <> c.currency)
+	`
+
+	res, err := tx.ExecContext(ctx, query, productID, PriceActive, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventProductUpdated, AggregateProduct, productID, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// recordPrice makes price the regular price of a product from now on. Any
+// scheduled change in effect ends early, since an explicit price overrides
+// it, and pending changes in another currency are cancelled.
+func recordPrice(ctx context.Context, tx *sql.Tx, productID int, price Money, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE product_prices SET status = $1, effective_to = LEAST(COALESCE(effective_to, $2), $2), updated_at = $2
+		WHERE product_id = $3 AND status = $4
+	`, PriceEnded, now, productID, PriceActive)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE product_prices SET status = $1, updated_at = $2
+		WHERE product_id = $3 AND status = $4 AND currency <> $5
+	`, PriceCancelled, now, productID, PricePending, price.Currency)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO product_prices (product_id, price, currency, effective_from, scheduled, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, false, $5, $4, $4)
+	`, productID, price, price.Currency, now, PriceActive)
+	return err
+}
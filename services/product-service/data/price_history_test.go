@@ -0,0 +1,33 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulePriceValidation(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		change PriceChange
+		want   []string
+	}{
+		{"past start", PriceChange{Price: Money{Amount: 100}, EffectiveFrom: past}, []string{"effectiveFrom"}},
+		{"end before start", PriceChange{Price: Money{Amount: 100}, EffectiveFrom: future, EffectiveTo: &now}, []string{"effectiveTo"}},
+		{"negative price", PriceChange{Price: Money{Amount: -1}, EffectiveFrom: future}, []string{"price"}},
+		{"all wrong", PriceChange{Price: Money{Amount: -1}, EffectiveFrom: past, EffectiveTo: &past}, []string{"effectiveFrom", "effectiveTo", "price"}},
+	}
+
+	// The model has no database, so these must fail before reaching it
+	m := &ProductModel{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.SchedulePrice(tt.change)
+			checkFields(t, err, tt.want)
+		})
+	}
+}
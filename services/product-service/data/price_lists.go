@@ -0,0 +1,706 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// Where a product's selling price came from.
+const (
+	PriceSourceBase         = "base"
+	PriceSourcePriceList    = "priceList"
+	PriceSourceExchangeRate = "exchangeRate"
+)
+
+var (
+	// ErrPriceListInactive is returned when a price list is selected outside
+	// its validity dates.
+	ErrPriceListInactive = errors.New("price list is not valid at this time")
+
+	// ErrNoExchangeRate is returned when a price has to be converted between
+	// currencies that have no exchange rate configured.
+	ErrNoExchangeRate = errors.New("no exchange rate configured")
+)
+
+var (
+	priceListCodeRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+	decimalRX       = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+)
+
+// PriceList is a named set of prices in one currency, optionally limited to
+// a customer group and to a period of time.
+type PriceList struct {
+	ID            int        `json:"priceListId"`
+	Code          string     `json:"code"`
+	Name          string     `json:"name"`
+	Currency      string     `json:"currency"`
+	CustomerGroup *string    `json:"customerGroup"`
+	ValidFrom     *time.Time `json:"validFrom"`
+	ValidTo       *time.Time `json:"validTo"`
+	CreatedAt     time.Time  `json:"-"`
+	UpdatedAt     time.Time  `json:"-"`
+}
+
+// PriceListEntry is the price of a product, or of one of its variants, in a
+// price list.
+type PriceListEntry struct {
+	ProductID int   `json:"productId"`
+	VariantID *int  `json:"variantId,omitempty"`
+	Price     Money `json:"price"`
+}
+
+// ExchangeRate is how many units of Quote one unit of Base buys.
+type ExchangeRate struct {
+	Base      string    `json:"base"`
+	Quote     string    `json:"quote"`
+	Rate      string    `json:"rate"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// PriceSelector chooses the prices products are shown with: a price list by
+// code, or the currency to price them in. CustomerGroup is the group of the
+// caller, which may use the lists limited to it.
+type PriceSelector struct {
+	Currency      string
+	PriceList     string
+	CustomerGroup string
+}
+
+// SellingPrice is the price of a product in the selected currency.
+type SellingPrice struct {
+	Price     Money  `json:"price"`
+	Source    string `json:"source"`
+	PriceList string `json:"priceList,omitempty"`
+}
+
+type PriceListModel struct {
+	DB *sql.DB
+}
+
+func (s PriceSelector) IsZero() bool {
+	return s.Currency == "" && s.PriceList == ""
+}
+
+func (s PriceSelector) Validate() error {
+	if s.Currency != "" {
+		return ValidateCurrency(s.Currency)
+	}
+	return nil
+}
+
+func (pl PriceList) Validate() error {
+	if !priceListCodeRX.MatchString(pl.Code) {
+		return errors.New("price list code must be lower case letters, digits, '-' and '_'")
+	}
+	if strings.TrimSpace(pl.Name) == "" {
+		return errors.New("price list name is required")
+	}
+	if pl.CustomerGroup != nil && strings.TrimSpace(*pl.CustomerGroup) == "" {
+		return errors.New("customerGroup must not be empty, leave it out to price for everyone")
+	}
+	if pl.ValidFrom != nil && pl.ValidTo != nil && !pl.ValidFrom.Before(*pl.ValidTo) {
+		return errors.New("validFrom must be before validTo")
+	}
+	return ValidateCurrency(pl.Currency)
+}
+
+// VisibleTo reports whether a caller in the given customer group may see and
+// use the list: lists limited to a group are hidden from everyone else.
+func (pl PriceList) VisibleTo(customerGroup string) bool {
+	return pl.CustomerGroup == nil || *pl.CustomerGroup == customerGroup
+}
+
+// activeAt reports whether the list is within its validity dates at t.
+func (pl PriceList) activeAt(t time.Time) bool {
+	return (pl.ValidFrom == nil || !t.Before(*pl.ValidFrom)) &&
+		(pl.ValidTo == nil || t.Before(*pl.ValidTo))
+}
+
+func (r ExchangeRate) Validate() error {
+	err := ValidateCurrency(r.Base)
+	if err != nil {
+		return err
+	}
+	err = ValidateCurrency(r.Quote)
+	if err != nil {
+		return err
+	}
+	if r.Base == r.Quote {
+		return errors.New("an exchange rate needs two different currencies")
+	}
+
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !decimalRX.MatchString(r.Rate) || !ok || rate.Sign() <= 0 {
+		return errors.New("rate must be a positive decimal number such as 1.0845")
+	}
+	return nil
+}
+
+const priceListColumns = `price_list_id, code, name, currency, customer_group, valid_from, valid_to, created_at, updated_at`
+
+func scanPriceList(row rowScanner) (*PriceList, error) {
+	var pl PriceList
+	err := row.Scan(
+		&pl.ID,
+		&pl.Code,
+		&pl.Name,
+		&pl.Currency,
+		&pl.CustomerGroup,
+		&pl.ValidFrom,
+		&pl.ValidTo,
+		&pl.CreatedAt,
+		&pl.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &pl, nil
+}
+
+func (m *PriceListModel) GetAll() ([]*PriceList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+priceListColumns+` FROM price_lists ORDER BY code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []*PriceList{}
+
+	for rows.Next() {
+		pl, err := scanPriceList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, pl)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (m *PriceListModel) GetOne(id int) (*PriceList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+priceListColumns+` FROM price_lists WHERE price_list_id = $1`, id)
+	return scanPriceList(row)
+}
+
+func (m *PriceListModel) Insert(pl PriceList) (*PriceList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := pl.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pl.CreatedAt = time.Now()
+	pl.UpdatedAt = pl.CreatedAt
+
+	query := `
+		INSERT INTO price_lists (code, name, currency, customer_group, valid_from, valid_to, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING price_list_id
+	`
+
+	err = m.DB.QueryRowContext(ctx, query,
+		pl.Code,
+		pl.Name,
+		pl.Currency,
+		pl.CustomerGroup,
+		pl.ValidFrom,
+		pl.ValidTo,
+		pl.CreatedAt,
+		pl.UpdatedAt,
+	).Scan(&pl.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pl, nil
+}
+
+// Update saves a price list. Its currency cannot change while it holds
+// prices, since they are amounts of that currency.
+func (m *PriceListModel) Update(pl PriceList) (*PriceList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := pl.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	pl.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE price_lists
+		SET code = $1, name = $2, currency = $3, customer_group = $4, valid_from = $5, valid_to = $6, updated_at = $7
+		WHERE price_list_id = $8 AND (currency = $3 OR NOT EXISTS (SELECT 1 FROM price_list_prices WHERE price_list_id = $8))
+		RETURNING created_at
+	`
+
+	err = m.DB.QueryRowContext(ctx, query,
+		pl.Code,
+		pl.Name,
+		pl.Currency,
+		pl.CustomerGroup,
+		pl.ValidFrom,
+		pl.ValidTo,
+		pl.UpdatedAt,
+		pl.ID,
+	).Scan(&pl.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = m.GetOne(pl.ID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("the currency of a price list cannot change while it holds prices")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pl, nil
+}
+
+// Delete removes a price list and its prices. It returns sql.ErrNoRows if
+// there is no such list.
+func (m *PriceListModel) Delete(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM price_lists WHERE price_list_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Prices returns the prices a price list holds for live products. Prices of
+// products that are not published are only returned with
+// includeUnpublished.
+func (m *PriceListModel) Prices(id int, includeUnpublished bool) ([]PriceListEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.GetOne(id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT pp.product_id, pp.variant_id, pp.price::text || ' ' || pl.currency
+		FROM price_list_prices pp
+		JOIN price_lists pl ON pl.price_list_id = pp.price_list_id
+		JOIN products p ON p.product_id = pp.product_id
+		WHERE pp.price_list_id = $1
+		  AND p.deleted_at IS NULL
+		  AND (p.status = $2 OR $3)
+		ORDER BY pp.product_id, pp.variant_id NULLS FIRST
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, id, ProductPublished, includeUnpublished)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []PriceListEntry{}
+
+	for rows.Next() {
+		var e PriceListEntry
+		err := rows.Scan(&e.ProductID, &e.VariantID, &e.Price)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// SetPrices adds entries to a price list, replacing any price it already
+// holds for the same product or variant. It returns sql.ErrNoRows if a
+// product does not exist or is archived.
+func (m *PriceListModel) SetPrices(id int, entries []PriceListEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	pl, err := m.GetOne(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range entries {
+		price := e.Price
+		if price.Currency == "" {
+			price, err = price.inCurrency(pl.Currency)
+			if err != nil {
+				return err
+			}
+		}
+		if price.Currency != pl.Currency {
+			return fmt.Errorf("prices in price list %s must be in %s", pl.Code, pl.Currency)
+		}
+		if price.IsNegative() {
+			return errors.New("price must not be negative")
+		}
+
+		var live bool
+		err = tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM products WHERE product_id = $1 AND deleted_at IS NULL)`,
+			e.ProductID,
+		).Scan(&live)
+		if err != nil {
+			return err
+		}
+		if !live {
+			return fmt.Errorf("product %d: %w", e.ProductID, sql.ErrNoRows)
+		}
+
+		if e.VariantID != nil {
+			var exists bool
+			err = tx.QueryRowContext(ctx,
+				`SELECT EXISTS (SELECT 1 FROM product_variants WHERE variant_id = $1 AND product_id = $2)`,
+				*e.VariantID, e.ProductID,
+			).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("product %d has no variant %d", e.ProductID, *e.VariantID)
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO price_list_prices (price_list_id, product_id, variant_id, price)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (price_list_id, product_id, COALESCE(variant_id, 0)) DO UPDATE SET price = EXCLUDED.price
+		`, id, e.ProductID, e.VariantID, price)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeletePrice removes the price a list holds for a product, or for one of
+// its variants if variantID is not nil.
+func (m *PriceListModel) DeletePrice(id, productID int, variantID *int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM price_list_prices
+		WHERE price_list_id = $1 AND product_id = $2 AND variant_id IS NOT DISTINCT FROM $3
+	`
+
+	res, err := m.DB.ExecContext(ctx, query, id, productID, variantID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *PriceListModel) ExchangeRates() ([]ExchangeRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT base_currency, quote_currency, rate::text, updated_at
+		FROM exchange_rates
+		ORDER BY base_currency, quote_currency
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []ExchangeRate{}
+
+	for rows.Next() {
+		var r ExchangeRate
+		err := rows.Scan(&r.Base, &r.Quote, &r.Rate, &r.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// SetExchangeRate saves the rate from r.Base to r.Quote. The opposite
+// direction uses its inverse unless it has a rate of its own.
+func (m *PriceListModel) SetExchangeRate(r ExchangeRate) (*ExchangeRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := r.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO exchange_rates (base_currency, quote_currency, rate, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (base_currency, quote_currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = EXCLUDED.updated_at
+		RETURNING rate::text
+	`
+
+	r.UpdatedAt = time.Now()
+	err = m.DB.QueryRowContext(ctx, query, r.Base, r.Quote, r.Rate, r.UpdatedAt).Scan(&r.Rate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Apply sets the SellingPrice of products, and of their variants, as chosen
+// by sel. A price held by the selected price list wins; a variant without
+// one of its own takes its product's. Anything else is converted from the
+// product's own price through the exchange rate table.
+func (m *PriceListModel) Apply(sel PriceSelector, products []*Product) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	list, err := m.selectPriceList(ctx, sel)
+	if err != nil {
+		return err
+	}
+
+	currency := sel.Currency
+	if list != nil {
+		currency = list.Currency
+	}
+
+	explicit := map[[2]int]Money{}
+	if list != nil && len(products) > 0 {
+		explicit, err = m.listPrices(ctx, list, products)
+		if err != nil {
+			return err
+		}
+	}
+
+	rates := map[[2]string]*big.Rat{}
+
+	price := func(productID, variantID int, base Money) (*SellingPrice, error) {
+		for _, key := range [][2]int{{productID, variantID}, {productID, 0}} {
+			if p, ok := explicit[key]; ok {
+				return &SellingPrice{Price: p, Source: PriceSourcePriceList, PriceList: list.Code}, nil
+			}
+		}
+
+		if base.Currency == currency {
+			return &SellingPrice{Price: base, Source: PriceSourceBase}, nil
+		}
+
+		pair := [2]string{base.Currency, currency}
+		rate, ok := rates[pair]
+		if !ok {
+			rate, err = exchangeRate(ctx, m.DB, base.Currency, currency)
+			if err != nil {
+				return nil, err
+			}
+			rates[pair] = rate
+		}
+
+		converted, err := base.Convert(currency, rate)
+		if err != nil {
+			return nil, err
+		}
+
+		return &SellingPrice{Price: converted, Source: PriceSourceExchangeRate}, nil
+	}
+
+	for _, p := range products {
+		p.SellingPrice, err = price(p.ID, 0, p.PriceUnit)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range p.Variants {
+			v.SellingPrice, err = price(p.ID, v.ID, v.Price)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// selectPriceList finds the price list sel names, or the one that applies
+// to sel's customer group, or else to everyone, in sel's currency right now.
+// It returns nil if sel names no list and none applies. A list limited to a
+// customer group can only be named by a caller in that group.
+func (m *PriceListModel) selectPriceList(ctx context.Context, sel PriceSelector) (*PriceList, error) {
+	now := time.Now()
+
+	if sel.PriceList != "" {
+		row := m.DB.QueryRowContext(ctx, `SELECT `+priceListColumns+` FROM price_lists WHERE code = $1`, sel.PriceList)
+		pl, err := scanPriceList(row)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("unknown price list %q", sel.PriceList)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !pl.VisibleTo(sel.CustomerGroup) {
+			return nil, fmt.Errorf("price list %s is only for customer group %s", pl.Code, *pl.CustomerGroup)
+		}
+		if sel.Currency != "" && sel.Currency != pl.Currency {
+			return nil, fmt.Errorf("price list %s is in %s, not %s", pl.Code, pl.Currency, sel.Currency)
+		}
+		if !pl.activeAt(now) {
+			return nil, fmt.Errorf("%w: %s", ErrPriceListInactive, pl.Code)
+		}
+		return pl, nil
+	}
+
+	// A list for the caller's group beats one for everyone, and of several
+	// lists valid at once, the one that started most recently wins
+	query := `
+		SELECT ` + priceListColumns + `
+		FROM price_lists
+		WHERE currency = $1 AND (customer_group IS NULL OR customer_group = $3)
+		  AND (valid_from IS NULL OR valid_from <= $2)
+		  AND (valid_to IS NULL OR valid_to > $2)
+		ORDER BY customer_group IS NULL, valid_from DESC NULLS LAST, price_list_id DESC
+		LIMIT 1
+	`
+
+	pl, err := scanPriceList(m.DB.QueryRowContext(ctx, query, sel.Currency, now, sel.CustomerGroup))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return pl, err
+}
+
+// listPrices returns the prices list holds for products and their variants,
+// keyed by product and variant ID, with 0 for the product's own price.
+func (m *PriceListModel) listPrices(ctx context.Context, list *PriceList, products []*Product) (map[[2]int]Money, error) {
+	ids := make([]int32, 0, len(products))
+	for _, p := range products {
+		ids = append(ids, int32(p.ID))
+	}
+
+	var productIDs pgtype.Int4Array
+	err := productIDs.Set(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT product_id, COALESCE(variant_id, 0), price::text
+		FROM price_list_prices
+		WHERE price_list_id = $1 AND product_id = ANY($2)
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, list.ID, &productIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := map[[2]int]Money{}
+
+	for rows.Next() {
+		var key [2]int
+		price := Money{Currency: list.Currency}
+
+		err := rows.Scan(&key[0], &key[1], &price)
+		if err != nil {
+			return nil, err
+		}
+		prices[key] = price
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// exchangeRate returns the rate from base to quote, or the inverse of the
+// rate from quote to base if only that one is configured.
+func exchangeRate(ctx context.Context, q queryer, base, quote string) (*big.Rat, error) {
+	query := `
+		SELECT rate::text, base_currency = $1
+		FROM exchange_rates
+		WHERE (base_currency = $1 AND quote_currency = $2) OR (base_currency = $2 AND quote_currency = $1)
+		ORDER BY base_currency = $1 DESC
+		LIMIT 1
+	`
+
+	var text string
+	var direct bool
+	err := q.QueryRowContext(ctx, query, base, quote).Scan(&text, &direct)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w from %s to %s", ErrNoExchangeRate, base, quote)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rate, ok := new(big.Rat).SetString(text)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q from %s to %s", text, base, quote)
+	}
+	if !direct {
+		rate.Inv(rate)
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Product statuses. Only published products are shown to the public.
+const (
+	ProductDraft       = "draft"
+	ProductInReview    = "in_review"
+	ProductPublished   = "published"
+	ProductUnpublished = "unpublished"
+)
+
+// statusTransitions lists the statuses a product may move to from each
+// status.
+var statusTransitions = map[string][]string{
+	ProductDraft:       {ProductInReview},
+	ProductInReview:    {ProductDraft, ProductPublished},
+	ProductPublished:   {ProductUnpublished},
+	ProductUnpublished: {ProductPublished, ProductDraft},
+}
+
+var (
+	// ErrStatusTransition is returned when a product cannot move from its
+	// status to the one asked for.
+	ErrStatusTransition = errors.New("status cannot change")
+	// ErrProductUnpublished is returned when stock is reserved for a
+	// product that is not published.
+	ErrProductUnpublished = errors.New("product is not published")
+)
+
+// StatusChange moves a product through its lifecycle. PublishAt and
+// UnpublishAt replace the product's schedule; nil clears them.
+type StatusChange struct {
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publishAt"`
+	UnpublishAt *time.Time `json:"unpublishAt"`
+}
+
+// Validate checks the change on its own, before it is applied to a product.
+func (s StatusChange) Validate(now time.Time) error {
+	var v ValidationError
+
+	if _, ok := statusTransitions[s.Status]; !ok {
+		v.Add("status", fmt.Sprintf("must be one of %s, %s, %s or %s", ProductDraft, ProductInReview, ProductPublished, ProductUnpublished))
+	}
+	if s.PublishAt != nil && s.UnpublishAt != nil && !s.UnpublishAt.After(*s.PublishAt) {
+		v.Add("unpublishAt", "must be after publishAt")
+	}
+	if s.Status == ProductPublished && s.UnpublishAt != nil && !s.UnpublishAt.After(now) {
+		v.Add("unpublishAt", "must be in the future for a published product")
+	}
+
+	return v.Err()
+}
+
+// SetStatus applies change to the live product with the given id. Keeping
+// the same status only changes the schedule. It returns ErrStatusTransition
+// if the product cannot move to the new status, and sql.ErrNoRows if there
+// is no such product.
+func (m *ProductModel) SetStatus(id int, change StatusChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	err := change.Validate(now)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx,
+		`SELECT status FROM products WHERE product_id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		id,
+	).Scan(&status)
+	if err != nil {
+		return err
+	}
+
+	if change.Status != status && !slices.Contains(statusTransitions[status], change.Status) {
+		return fmt.Errorf("%w from %s to %s", ErrStatusTransition, status, change.Status)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE products
+		SET status = $1, publish_at = $2, unpublish_at = $3, updated_at = $4
+		WHERE product_id = $5
+	`, change.Status, change.PublishAt, change.UnpublishAt, now, id)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, statusEvent(status, change.Status), AggregateProduct, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// statusEvent picks the event published when a product moves from one
+// status to another.
+func statusEvent(from, to string) string {
+	switch {
+	case to == ProductPublished && from != ProductPublished:
+		return EventProductPublished
+	case from == ProductPublished && to != ProductPublished:
+		return EventProductUnpublished
+	default:
+		return EventProductUpdated
+	}
+}
+
+// ApplyPublishSchedule publishes products in review whose publish time has
+// come, and unpublishes published products whose unpublish time has come.
+// It returns how many products it published and unpublished.
+func (m *ProductModel) ApplyPublishSchedule() (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	published, err := moveScheduled(ctx, tx, ProductInReview, ProductPublished, "publish_at", now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	unpublished, err := moveScheduled(ctx, tx, ProductPublished, ProductUnpublished, "unpublish_at", now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return published, unpublished, tx.Commit()
+}
+
+// moveScheduled moves live products from one status to another once the
+// time in column has passed, publishing an event for each.
+func moveScheduled(ctx context.Context, tx *sql.Tx, from, to, column string, now time.Time) (int, error) {
+	ids, err := returningIDs(ctx, tx, `
+		UPDATE products
+		SET status = $1, updated_at = $2
+		WHERE status = $3 AND `+column+` <= $2 AND deleted_at IS NULL
+		RETURNING product_id
+	`, to, now, from)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		err = enqueueCatalogEvent(ctx, tx, statusEvent(from, to), AggregateProduct, id, nil)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), nil
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusEvent(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{ProductInReview, ProductPublished, EventProductPublished},
+		{ProductUnpublished, ProductPublished, EventProductPublished},
+		{ProductPublished, ProductUnpublished, EventProductUnpublished},
+		{ProductPublished, ProductPublished, EventProductUpdated},
+		{ProductDraft, ProductInReview, EventProductUpdated},
+		{ProductUnpublished, ProductDraft, EventProductUpdated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			if got := statusEvent(tt.from, tt.to); got != tt.want {
+				t.Errorf("statusEvent(%s, %s) = %s, want %s", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusChangeValidate(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name       string
+		change     StatusChange
+		wantFields []string
+	}{
+		{"publish", StatusChange{Status: ProductPublished}, nil},
+		{"scheduled", StatusChange{Status: ProductInReview, PublishAt: at(time.Hour), UnpublishAt: at(2 * time.Hour)}, nil},
+		{"unknown status", StatusChange{Status: "archived"}, []string{"status"}},
+		{"unpublish before publish", StatusChange{Status: ProductInReview, PublishAt: at(2 * time.Hour), UnpublishAt: at(time.Hour)}, []string{"unpublishAt"}},
+		{"unpublish at publish", StatusChange{Status: ProductInReview, PublishAt: at(time.Hour), UnpublishAt: at(time.Hour)}, []string{"unpublishAt"}},
+		{"published with past unpublish", StatusChange{Status: ProductPublished, UnpublishAt: at(-time.Hour)}, []string{"unpublishAt"}},
+		{"draft with past unpublish", StatusChange{Status: ProductDraft, UnpublishAt: at(-time.Hour)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFields(t, tt.change.Validate(now), tt.wantFields)
+		})
+	}
+}
@@ -0,0 +1,460 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	ReservationHeld      = "held"
+	ReservationConfirmed = "confirmed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+var (
+	// ErrInsufficientStock is returned when a reservation asks for more than
+	// a product has available.
+	ErrInsufficientStock = errors.New("insufficient stock")
+
+	// ErrReservationNotHeld is returned when confirming or releasing a
+	// reservation that is no longer held.
+	ErrReservationNotHeld = errors.New("reservation is not held")
+)
+
+type Reservation struct {
+	ID        int               `json:"reservationId"`
+	Reference string            `json:"reference"`
+	Status    string            `json:"status"`
+	Items     []ReservationItem `json:"items"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	CreatedAt time.Time         `json:"-"`
+	UpdatedAt time.Time         `json:"-"`
+}
+
+// ReservationItem is a quantity of a product held by a reservation. Products
+// with variants are reserved per variant. An item may name the warehouse it
+// ships from, such as one picked by an allocation, and is then held in that
+// warehouse's stock and taken from it when confirmed.
+type ReservationItem struct {
+	ProductID   int  `json:"productId"`
+	VariantID   *int `json:"variantId,omitempty"`
+	WarehouseID *int `json:"warehouseId,omitempty"`
+	Quantity    int  `json:"quantity"`
+}
+
+type ReservationModel struct {
+	DB *sql.DB
+}
+
+// Hold reserves the quantities in items for reference until expiresAt. Either
+// every item is reserved or none is; ErrInsufficientStock is returned if any
+// product does not have enough available stock, and sql.ErrNoRows if a
+// product or variant does not exist.
+func (m *ReservationModel) Hold(reference string, items []ReservationItem, expiresAt time.Time) (*Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	items, err := mergeReservationItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	r := Reservation{
+		Reference: reference,
+		Status:    ReservationHeld,
+		Items:     items,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	query := `
+		INSERT INTO stock_reservations (reference, status, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING reservation_id
+	`
+
+	err = tx.QueryRowContext(ctx, query, r.Reference, r.Status, r.ExpiresAt, now, now).Scan(&r.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Items are sorted by product, so concurrent holds lock rows in the same order
+	for _, item := range items {
+		err = holdItem(ctx, tx, item, now)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO stock_reservation_items (reservation_id, product_id, variant_id, warehouse_id, quantity)
+			VALUES ($1, $2, $3, $4, $5)
+		`, r.ID, item.ProductID, item.VariantID, item.WarehouseID, item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// holdItem adds item's quantity to what is reserved of its product, and of
+// its variant and warehouse if it names them, provided enough is available.
+func holdItem(ctx context.Context, tx *sql.Tx, item ReservationItem, now time.Time) error {
+	var deleted bool
+	var status string
+	err := tx.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL, status FROM products WHERE product_id = $1`, item.ProductID).Scan(&deleted, &status)
+	if err != nil {
+		return fmt.Errorf("product %d: %w", item.ProductID, err)
+	}
+	if deleted {
+		return fmt.Errorf("%w: product %d", ErrProductDeleted, item.ProductID)
+	}
+	if status != ProductPublished {
+		return fmt.Errorf("%w: product %d", ErrProductUnpublished, item.ProductID)
+	}
+
+	if item.VariantID != nil {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE product_variants
+			SET reserved = reserved + $1, updated_at = $2
+			WHERE variant_id = $3 AND product_id = $4 AND quantity - reserved >= $1
+		`, item.Quantity, now, *item.VariantID, item.ProductID)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			var exists bool
+			err = tx.QueryRowContext(ctx,
+				`SELECT EXISTS (SELECT 1 FROM product_variants WHERE variant_id = $1 AND product_id = $2)`,
+				*item.VariantID, item.ProductID,
+			).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("variant %d of product %d: %w", *item.VariantID, item.ProductID, sql.ErrNoRows)
+			}
+			return fmt.Errorf("%w for variant %d of product %d", ErrInsufficientStock, *item.VariantID, item.ProductID)
+		}
+	} else {
+		var hasVariants bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1)`, item.ProductID).Scan(&hasVariants)
+		if err != nil {
+			return err
+		}
+		if hasVariants {
+			return fmt.Errorf("%w: product %d", ErrVariantRequired, item.ProductID)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE products
+		SET reserved = reserved + $1, updated_at = $2
+		WHERE product_id = $3 AND COALESCE(quantity, 0) - reserved >= $1
+	`, item.Quantity, now, item.ProductID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w for product %d", ErrInsufficientStock, item.ProductID)
+	}
+
+	if item.WarehouseID != nil {
+		return changeWarehouseReserved(ctx, tx, *item.WarehouseID, item.ProductID, item.Quantity, now)
+	}
+
+	return nil
+}
+
+// mergeReservationItems validates items, combines repeated products and
+// variants from the same warehouse, and sorts the result by product,
+// variant and warehouse ID.
+func mergeReservationItems(items []ReservationItem) ([]ReservationItem, error) {
+	if len(items) == 0 {
+		return nil, errors.New("reservation must contain at least one item")
+	}
+
+	type key struct{ product, variant, warehouse int }
+
+	totals := map[key]int{}
+	var merged []ReservationItem
+
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("quantity for product %d must be greater than zero", item.ProductID)
+		}
+
+		k := key{product: item.ProductID, variant: idOrder(item.VariantID), warehouse: idOrder(item.WarehouseID)}
+
+		if _, ok := totals[k]; !ok {
+			merged = append(merged, ReservationItem{ProductID: item.ProductID, VariantID: item.VariantID, WarehouseID: item.WarehouseID})
+		}
+		totals[k] += item.Quantity
+	}
+
+	for i, item := range merged {
+		k := key{product: item.ProductID, variant: idOrder(item.VariantID), warehouse: idOrder(item.WarehouseID)}
+		merged[i].Quantity = totals[k]
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].ProductID != merged[j].ProductID {
+			return merged[i].ProductID < merged[j].ProductID
+		}
+		if idOrder(merged[i].VariantID) != idOrder(merged[j].VariantID) {
+			return idOrder(merged[i].VariantID) < idOrder(merged[j].VariantID)
+		}
+		return idOrder(merged[i].WarehouseID) < idOrder(merged[j].WarehouseID)
+	})
+
+	return merged, nil
+}
+
+// idOrder sorts an optional ID, with none first.
+func idOrder(id *int) int {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
+
+func (m *ReservationModel) GetOne(id int) (*Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return getReservation(ctx, m.DB, id, false)
+}
+
+// Confirm turns a held reservation into a sale, taking its quantities out of
+// stock for good.
+func (m *ReservationModel) Confirm(id int) (*Reservation, error) {
+	return m.settle(id, ReservationConfirmed)
+}
+
+// Release gives a held reservation's quantities back to available stock.
+func (m *ReservationModel) Release(id int) (*Reservation, error) {
+	return m.settle(id, ReservationReleased)
+}
+
+func (m *ReservationModel) settle(id int, status string) (*Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	r, err := getReservation(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if r.Status != ReservationHeld {
+		return nil, fmt.Errorf("%w: reservation %d is %s", ErrReservationNotHeld, id, r.Status)
+	}
+	if status == ReservationConfirmed && !r.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("%w: reservation %d has expired", ErrReservationNotHeld, id)
+	}
+
+	for _, item := range r.Items {
+		if item.VariantID != nil {
+			_, err = tx.ExecContext(ctx,
+				`UPDATE product_variants SET reserved = reserved - $1, updated_at = $2 WHERE variant_id = $3`,
+				item.Quantity, now, *item.VariantID,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE products SET reserved = reserved - $1, updated_at = $2 WHERE product_id = $3`,
+			item.Quantity, now, item.ProductID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if item.WarehouseID != nil {
+			err = changeWarehouseReserved(ctx, tx, *item.WarehouseID, item.ProductID, -item.Quantity, now)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// A confirmed hold leaves stock for good, from the warehouse it was
+		// held in, which the ledger records
+		if status == ReservationConfirmed {
+			_, err = recordMovement(ctx, tx, InventoryMovement{
+				ProductID:   item.ProductID,
+				VariantID:   item.VariantID,
+				WarehouseID: item.WarehouseID,
+				Change:      -item.Quantity,
+				Reason:      ReasonReservation,
+				Actor:       SystemActor,
+				ReferenceID: r.Reference,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE stock_reservations SET status = $1, updated_at = $2 WHERE reservation_id = $3`, status, now, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Status = status
+	r.UpdatedAt = now
+
+	return r, nil
+}
+
+// ExpireHeld releases every held reservation whose expiry has passed and
+// returns how many it expired.
+func (m *ReservationModel) ExpireHeld() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		WITH expired AS (
+			UPDATE stock_reservations
+			SET status = $1, updated_at = $2
+			WHERE status = $3 AND expires_at <= $2
+			RETURNING reservation_id
+		), released AS (
+			UPDATE products p
+			SET reserved = p.reserved - i.quantity, updated_at = $2
+			FROM (
+				SELECT product_id, sum(quantity) AS quantity
+				FROM stock_reservation_items
+				WHERE reservation_id IN (SELECT reservation_id FROM expired)
+				GROUP BY product_id
+			) i
+			WHERE p.product_id = i.product_id
+		), released_variants AS (
+			UPDATE product_variants v
+			SET reserved = v.reserved - i.quantity, updated_at = $2
+			FROM (
+				SELECT variant_id, sum(quantity) AS quantity
+				FROM stock_reservation_items
+				WHERE reservation_id IN (SELECT reservation_id FROM expired) AND variant_id IS NOT NULL
+				GROUP BY variant_id
+			) i
+			WHERE v.variant_id = i.variant_id
+		), released_warehouses AS (
+			UPDATE warehouse_stock s
+			SET reserved = s.reserved - i.quantity, updated_at = $2
+			FROM (
+				SELECT warehouse_id, product_id, sum(quantity) AS quantity
+				FROM stock_reservation_items
+				WHERE reservation_id IN (SELECT reservation_id FROM expired) AND warehouse_id IS NOT NULL
+				GROUP BY warehouse_id, product_id
+			) i
+			WHERE s.warehouse_id = i.warehouse_id AND s.product_id = i.product_id
+		)
+		SELECT count(*) FROM expired
+	`
+
+	var n int
+	err := m.DB.QueryRowContext(ctx, query, ReservationExpired, time.Now(), ReservationHeld).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// queryer is the part of *sql.DB and *sql.Tx used by helpers that run in
+// either.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getReservation(ctx context.Context, q queryer, id int, forUpdate bool) (*Reservation, error) {
+	query := `
+		SELECT reservation_id, reference, status, expires_at, created_at, updated_at
+		FROM stock_reservations
+		WHERE reservation_id = $1
+	`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var r Reservation
+	err := q.QueryRowContext(ctx, query, id).Scan(
+		&r.ID,
+		&r.Reference,
+		&r.Status,
+		&r.ExpiresAt,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT product_id, variant_id, warehouse_id, quantity
+		FROM stock_reservation_items
+		WHERE reservation_id = $1
+		ORDER BY product_id, variant_id NULLS FIRST, warehouse_id NULLS FIRST
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item ReservationItem
+		err := rows.Scan(&item.ProductID, &item.VariantID, &item.WarehouseID, &item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		r.Items = append(r.Items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
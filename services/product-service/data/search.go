@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// SearchResult is a product matched by ProductModel.Search, with its rank and
+// a snippet of the title with the matched terms wrapped in <mark> tags. The
+// rest of the snippet is HTML-escaped, so it can be rendered as HTML as is.
+type SearchResult struct {
+	Product   *Product `json:"product"`
+	Rank      float64  `json:"rank"`
+	Highlight string   `json:"highlight"`
+}
+
+// Search runs a ranked full-text search over product title, SKU and category
+// title. Every term is matched as a prefix; products whose title or SKU are
+// only a close trigram match are returned too, ranked by similarity. Only
+// published products are searched.
+func (m *ProductModel) Search(q string, opts ListOptions) ([]*SearchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tsquery := prefixTSQuery(q)
+	if tsquery == "" {
+		return nil, errors.New("search query must contain at least one letter or digit")
+	}
+
+	var args queryArgs
+	tsq := args.add(tsquery)
+	raw := args.add(strings.TrimSpace(q))
+
+	query := `
+		SELECT ` + productColumns + `,
+		       ts_rank(p.search_vector || setweight(to_tsvector('simple', coalesce(c.category_title, '')), 'B'), tq.q)
+		         + greatest(similarity(coalesce(p.product_title, ''), ` + raw + `), similarity(coalesce(p.sku, ''), ` + raw + `)) AS rank,
+		       ts_headline('simple', ` + escapedTitle + `, tq.q,
+		         'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS highlight
+		FROM products p
+		LEFT JOIN categories c ON p.category_id = c.category_id
+		CROSS JOIN to_tsquery('simple', ` + tsq + `) AS tq(q)
+		WHERE p.deleted_at IS NULL AND p.status = '` + ProductPublished + `'
+		  AND ((p.search_vector || to_tsvector('simple', coalesce(c.category_title, ''))) @@ tq.q
+		   OR p.product_title % ` + raw + `
+		   OR p.sku % ` + raw + `)
+		ORDER BY rank DESC, p.product_id
+		LIMIT ` + args.add(opts.Size) + ` OFFSET ` + args.add(opts.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+
+	for rows.Next() {
+		var res SearchResult
+
+		p, err := scanProduct(rows, &res.Rank, &res.Highlight)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Product = p
+		results = append(results, &res)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// escapedTitle is the product title with the characters that are special in
+// HTML text escaped. The title is escaped before ts_headline adds its <mark>
+// tags, so those are the only markup in a highlight.
+const escapedTitle = `replace(replace(replace(coalesce(p.product_title, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`
+
+// prefixTSQuery turns free text into a tsquery that matches every term as a
+// prefix, e.g. "wireless keyb" becomes "wireless:* & keyb:*". Anything other
+// than letters and digits separates terms, so user input can never inject
+// tsquery operators.
+func prefixTSQuery(q string) string {
+	terms := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, t := range terms {
+		terms[i] = t + ":*"
+	}
+
+	return strings.Join(terms, " & ")
+}
@@ -0,0 +1,562 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgtype"
+)
+
+// maxVariants caps how many combinations a product's options may generate.
+const maxVariants = 250
+
+var (
+	// ErrVariantRequired is returned when stock of a product with variants
+	// is changed or reserved without naming a variant.
+	ErrVariantRequired = errors.New("product has variants, stock must be managed per variant")
+
+	// ErrVariantInUse is returned when redefining options would remove a
+	// variant that still holds stock, or would hide stock held by the
+	// product itself.
+	ErrVariantInUse = errors.New("variant still holds stock")
+)
+
+// ProductOption is one dimension a product varies along, such as size or
+// colour, with the values it can take.
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductVariant is one combination of option values of a product, stocked
+// and sold as its own item.
+type ProductVariant struct {
+	ID            int               `json:"variantId"`
+	ProductID     int               `json:"productId"`
+	SKU           string            `json:"sku"`
+	Options       map[string]string `json:"options"`
+	PriceOverride *Money            `json:"priceOverride"`
+	Price         Money             `json:"price"`
+	SellingPrice  *SellingPrice     `json:"sellingPrice,omitempty"`
+	Quantity      int               `json:"quantity"`
+	Reserved      int               `json:"reserved"`
+	Available     int               `json:"available"`
+	ImageURL      string            `json:"imageUrl"`
+	CreatedAt     time.Time         `json:"-"`
+	UpdatedAt     time.Time         `json:"-"`
+}
+
+// Validate checks the fields of a variant that a client sets. It returns a
+// *ValidationError listing every field at fault.
+func (v ProductVariant) Validate() error {
+	var verr ValidationError
+	if problem := validateSKU(v.SKU); problem != "" {
+		verr.Add("sku", problem)
+	}
+	return verr.Err()
+}
+
+type VariantModel struct {
+	DB *sql.DB
+}
+
+// Options returns the options of a product in order.
+func (m *VariantModel) Options(productID int) ([]ProductOption, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return getOptions(ctx, m.DB, productID)
+}
+
+// GetAll returns the variants of a product.
+func (m *VariantModel) GetAll(productID int) ([]*ProductVariant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return getVariants(ctx, m.DB, `v.product_id = $1`, productID)
+}
+
+func (m *VariantModel) GetOne(productID, variantID int) (*ProductVariant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	variants, err := getVariants(ctx, m.DB, `v.product_id = $1 AND v.variant_id = $2`, productID, variantID)
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return variants[0], nil
+}
+
+// SetOptions replaces the options of a product and regenerates its variants
+// to match: one variant per combination of option values. Variants whose
+// combination still exists are kept with their SKU, price and stock; new
+// combinations get a variant with a generated SKU and no stock. It returns
+// ErrVariantInUse if a variant that would be removed still holds stock, or
+// if a product without variants holds stock of its own, and sql.ErrNoRows
+// if the product does not exist or is archived. An empty options list
+// removes all variants.
+func (m *VariantModel) SetOptions(productID int, options []ProductOption) ([]*ProductVariant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := validateOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var sku string
+	var quantity, reserved int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(sku, ''), COALESCE(quantity, 0), reserved FROM products WHERE product_id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		productID,
+	).Scan(&sku, &quantity, &reserved)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := getVariants(ctx, tx, `v.product_id = $1`, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stock held by the product itself has no variant to move to
+	if len(existing) == 0 && len(options) > 0 && (quantity != 0 || reserved != 0) {
+		return nil, fmt.Errorf("%w: bring the product's own stock to zero before adding options", ErrVariantInUse)
+	}
+
+	combos := optionCombinations(options)
+	wanted := map[string]map[string]string{}
+	for _, combo := range combos {
+		wanted[comboKey(options, combo)] = combo
+	}
+
+	for _, v := range existing {
+		key := comboKey(options, v.Options)
+		if _, ok := wanted[key]; ok && len(v.Options) == len(options) {
+			delete(wanted, key)
+			continue
+		}
+
+		if v.Quantity != 0 || v.Reserved != 0 {
+			return nil, fmt.Errorf("%w: variant %s has %d in stock and %d reserved", ErrVariantInUse, v.SKU, v.Quantity, v.Reserved)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM product_variants WHERE variant_id = $1`, v.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_options WHERE product_id = $1`, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, o := range options {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO product_options (product_id, option_name, option_values, position) VALUES ($1, $2, $3, $4)`,
+			productID, o.Name, textArray(o.Values), i,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+
+	// Insert in combination order so new variants are numbered predictably
+	for _, combo := range combos {
+		if _, ok := wanted[comboKey(options, combo)]; !ok {
+			continue
+		}
+
+		encoded, err := json.Marshal(combo)
+		if err != nil {
+			return nil, err
+		}
+
+		generated, err := uniqueSKU(variantSKU(sku, productID, options, combo), func(s string) (bool, error) {
+			return skuTaken(ctx, tx, s)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO product_variants (product_id, sku, options, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $4)
+		`, productID, generated, encoded, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventProductUpdated, AggregateProduct, productID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := getVariants(ctx, tx, `v.product_id = $1`, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// Update saves a variant's SKU, price override and image. Stock is changed
+// through inventory movements. It returns sql.ErrNoRows if the product is
+// archived.
+func (m *VariantModel) Update(variant ProductVariant) (*ProductVariant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := variant.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var currency string
+	err = tx.QueryRowContext(ctx,
+		`SELECT currency FROM products WHERE product_id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		variant.ProductID,
+	).Scan(&currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// An override is always in the currency of the product's own price
+	if variant.PriceOverride != nil {
+		if variant.PriceOverride.Currency == "" {
+			override, err := variant.PriceOverride.inCurrency(currency)
+			if err != nil {
+				return nil, err
+			}
+			variant.PriceOverride = &override
+		}
+		if variant.PriceOverride.Currency != currency {
+			return nil, fmt.Errorf("variant priceOverride must be in the product's currency %s", currency)
+		}
+		if variant.PriceOverride.IsNegative() {
+			return nil, errors.New("variant priceOverride must not be negative")
+		}
+	}
+
+	query := `
+		UPDATE product_variants
+		SET sku = $1, price_override = $2, image_url = $3, updated_at = $4
+		WHERE variant_id = $5 AND product_id = $6
+	`
+
+	res, err := tx.ExecContext(ctx, query,
+		variant.SKU,
+		variant.PriceOverride,
+		variant.ImageURL,
+		time.Now(),
+		variant.ID,
+		variant.ProductID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	err = enqueueCatalogEvent(ctx, tx, EventProductUpdated, AggregateProduct, variant.ProductID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := getVariants(ctx, tx, `v.variant_id = $1`, variant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return variants[0], nil
+}
+
+func validateOptions(options []ProductOption) error {
+	names := map[string]bool{}
+	combinations := 1
+
+	for _, o := range options {
+		name := strings.ToLower(strings.TrimSpace(o.Name))
+		if name == "" {
+			return errors.New("option name is required")
+		}
+		if names[name] {
+			return fmt.Errorf("option %q is defined twice", o.Name)
+		}
+		names[name] = true
+
+		if len(o.Values) == 0 {
+			return fmt.Errorf("option %q must have at least one value", o.Name)
+		}
+
+		values := map[string]bool{}
+		for _, v := range o.Values {
+			key := strings.ToLower(strings.TrimSpace(v))
+			if key == "" {
+				return fmt.Errorf("option %q has an empty value", o.Name)
+			}
+			if values[key] {
+				return fmt.Errorf("option %q has the value %q twice", o.Name, v)
+			}
+			values[key] = true
+		}
+
+		combinations *= len(o.Values)
+		if combinations > maxVariants {
+			return fmt.Errorf("options would generate more than %d variants", maxVariants)
+		}
+	}
+
+	return nil
+}
+
+// optionCombinations returns every combination of option values, varying
+// the last option fastest.
+func optionCombinations(options []ProductOption) []map[string]string {
+	if len(options) == 0 {
+		return nil
+	}
+
+	combos := []map[string]string{{}}
+	for _, o := range options {
+		var next []map[string]string
+		for _, combo := range combos {
+			for _, v := range o.Values {
+				c := make(map[string]string, len(combo)+1)
+				for k, val := range combo {
+					c[k] = val
+				}
+				c[o.Name] = v
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+
+	return combos
+}
+
+// comboKey identifies a combination of values of options.
+func comboKey(options []ProductOption, combo map[string]string) string {
+	parts := make([]string, len(options))
+	for i, o := range options {
+		parts[i] = combo[o.Name]
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// variantSKU generates a SKU for a new variant from the product SKU and the
+// variant's values, e.g. "TSHIRT-M-RED". Only the characters a SKU may hold
+// are kept, and it is cut to the longest a SKU may be. Values that differ
+// only in case or punctuation give the same SKU; uniqueSKU sets them apart.
+func variantSKU(productSKU string, productID int, options []ProductOption, combo map[string]string) string {
+	base := strings.Map(func(r rune) rune {
+		if isSKUChar(r) {
+			return r
+		}
+		return -1
+	}, productSKU)
+	if !skuPattern.MatchString(base) {
+		base = fmt.Sprintf("P%d", productID)
+	}
+
+	parts := []string{base}
+	for _, o := range options {
+		v := strings.Map(func(r rune) rune {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return unicode.ToUpper(r)
+			}
+			return -1
+		}, combo[o.Name])
+		parts = append(parts, v)
+	}
+
+	return truncateSKU(strings.Join(parts, "-"), maxSKULength)
+}
+
+// isSKUChar reports whether a SKU may hold r.
+func isSKUChar(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_')
+}
+
+// truncateSKU cuts sku to at most n characters. A SKU only holds ASCII, so
+// bytes are characters.
+func truncateSKU(sku string, n int) string {
+	if len(sku) > n {
+		return sku[:n]
+	}
+	return sku
+}
+
+// uniqueSKU returns sku, or if taken says it is already used, the first of
+// sku-2, sku-3 and so on that is not, cutting sku short to make room for
+// the suffix.
+func uniqueSKU(sku string, taken func(string) (bool, error)) (string, error) {
+	candidate := sku
+	for n := 2; ; n++ {
+		used, err := taken(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !used {
+			return candidate, nil
+		}
+		suffix := fmt.Sprintf("-%d", n)
+		candidate = truncateSKU(sku, maxSKULength-len(suffix)) + suffix
+	}
+}
+
+// skuTaken reports whether a live product or any variant has the SKU,
+// ignoring case. Product and variant SKUs share one namespace.
+func skuTaken(ctx context.Context, q queryer, sku string) (bool, error) {
+	var taken bool
+	err := q.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM products WHERE lower(sku) = lower($1) AND deleted_at IS NULL)
+		    OR EXISTS (SELECT 1 FROM product_variants WHERE lower(sku) = lower($1))
+	`, sku).Scan(&taken)
+	return taken, err
+}
+
+func textArray(values []string) *pgtype.TextArray {
+	var a pgtype.TextArray
+	_ = a.Set(values)
+	return &a
+}
+
+func getOptions(ctx context.Context, q queryer, productID int) ([]ProductOption, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT option_name, option_values
+		FROM product_options
+		WHERE product_id = $1
+		ORDER BY position
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	options := []ProductOption{}
+
+	for rows.Next() {
+		var o ProductOption
+		var values pgtype.TextArray
+
+		err := rows.Scan(&o.Name, &values)
+		if err != nil {
+			return nil, err
+		}
+
+		err = values.AssignTo(&o.Values)
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return options, nil
+}
+
+// getVariants returns the variants matching where, which may refer to
+// product_variants as v and products as p.
+func getVariants(ctx context.Context, q queryer, where string, args ...any) ([]*ProductVariant, error) {
+	query := `
+		SELECT v.variant_id, v.product_id, v.sku, v.options, v.price_override::text || ' ' || p.currency,
+		       COALESCE(v.price_override, p.price_unit, 0)::text || ' ' || p.currency,
+		       v.quantity, v.reserved, COALESCE(v.image_url, ''), v.created_at, v.updated_at
+		FROM product_variants v
+		JOIN products p ON p.product_id = v.product_id
+		WHERE ` + where + `
+		ORDER BY v.variant_id
+	`
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []*ProductVariant{}
+
+	for rows.Next() {
+		var v ProductVariant
+		var options []byte
+
+		err := rows.Scan(
+			&v.ID,
+			&v.ProductID,
+			&v.SKU,
+			&options,
+			&v.PriceOverride,
+			&v.Price,
+			&v.Quantity,
+			&v.Reserved,
+			&v.ImageURL,
+			&v.CreatedAt,
+			&v.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(options, &v.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		v.Available = v.Quantity - v.Reserved
+		variants = append(variants, &v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
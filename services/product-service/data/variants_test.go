@@ -0,0 +1,105 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVariantSKU(t *testing.T) {
+	options := []ProductOption{
+		{Name: "Size", Values: []string{"M", "XL"}},
+		{Name: "Colour", Values: []string{"Navy Blue", "red"}},
+	}
+
+	tests := []struct {
+		name       string
+		productSKU string
+		combo      map[string]string
+		want       string
+	}{
+		{"values upper-cased", "TSHIRT", map[string]string{"Size": "M", "Colour": "red"}, "TSHIRT-M-RED"},
+		{"punctuation dropped", "TSHIRT", map[string]string{"Size": "XL", "Colour": "Navy Blue"}, "TSHIRT-XL-NAVYBLUE"},
+		{"product without SKU", "", map[string]string{"Size": "M", "Colour": "red"}, "P7-M-RED"},
+		{"letters a SKU cannot hold dropped", "TS/01", map[string]string{"Size": "M", "Colour": "Grün"}, "TS01-M-GRN"},
+		{"product SKU that cannot start one", "--", map[string]string{"Size": "M", "Colour": "red"}, "P7-M-RED"},
+		{"cut to the longest SKU", strings.Repeat("A", 62), map[string]string{"Size": "XL", "Colour": "red"}, strings.Repeat("A", 62) + "-X"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := variantSKU(tt.productSKU, 7, options, tt.combo); got != tt.want {
+				t.Errorf("variantSKU() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueSKU(t *testing.T) {
+	tests := []struct {
+		name    string
+		taken   []string
+		want    string
+		wantErr bool
+	}{
+		{"free", nil, "TS-M", false},
+		{"taken", []string{"TS-M"}, "TS-M-2", false},
+		{"several taken", []string{"TS-M", "TS-M-2", "TS-M-3"}, "TS-M-4", false},
+		{"cut short for the suffix", []string{"TS-M", "TS-M-2", "TS-M-3", "TS-M-4", "TS-M-5", "TS-M-6", "TS-M-7", "TS-M-8", "TS-M-9"}, "TS-M-10", false},
+		{"lookup fails", []string{"error"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used := map[string]bool{}
+			for _, s := range tt.taken {
+				used[s] = true
+			}
+
+			got, err := uniqueSKU("TS-M", func(s string) (bool, error) {
+				if used["error"] {
+					return false, errors.New("connection lost")
+				}
+				return used[s], nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("uniqueSKU() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("uniqueSKU() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueSKUKeepsToTheLongestSKU(t *testing.T) {
+	sku := strings.Repeat("A", maxSKULength)
+
+	got, err := uniqueSKU(sku, func(s string) (bool, error) { return s == sku, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("A", maxSKULength-2) + "-2"; got != want {
+		t.Errorf("uniqueSKU() = %q, want %q", got, want)
+	}
+}
+
+func TestVariantValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		sku        string
+		wantFields []string
+	}{
+		{"valid", "TSHIRT-M-RED", nil},
+		{"empty", "", []string{"sku"}},
+		{"spaces", "TSHIRT M", []string{"sku"}},
+		{"slash", "TSHIRT/M", []string{"sku"}},
+		{"too long", strings.Repeat("A", maxSKULength+1), []string{"sku"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFields(t, ProductVariant{SKU: tt.sku}.Validate(), tt.wantFields)
+		})
+	}
+}
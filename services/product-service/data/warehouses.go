@@ -0,0 +1,626 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// Allocation strategies, deciding which warehouses are tried first.
+const (
+	// AllocateByPriority tries warehouses in order of priority.
+	AllocateByPriority = "priority"
+	// AllocateByNearest tries warehouses in the order's region first, then
+	// the rest in order of priority.
+	AllocateByNearest = "nearest"
+)
+
+// maxWarehouseCodeLength is the longest code a warehouse may have.
+const maxWarehouseCodeLength = 32
+
+// ErrDefaultWarehouse is returned when a change would leave no active
+// default warehouse to receive stock.
+var ErrDefaultWarehouse = errors.New("the default warehouse must stay active")
+
+// Warehouse is a location stock is kept and shipped from. Stock moved
+// without naming a warehouse is received into the default warehouse.
+type Warehouse struct {
+	ID     int    `json:"warehouseId"`
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Region string `json:"region"`
+	// Priority orders warehouses for allocation, lowest first.
+	Priority  int       `json:"priority"`
+	Active    bool      `json:"active"`
+	IsDefault bool      `json:"isDefault"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// Validate checks the fields of a warehouse on their own.
+func (wh Warehouse) Validate() error {
+	var v ValidationError
+
+	switch {
+	case wh.Code == "":
+		v.Add("code", "must not be empty")
+	case len(wh.Code) > maxWarehouseCodeLength:
+		v.Add("code", fmt.Sprintf("must be at most %d characters", maxWarehouseCodeLength))
+	case !skuPattern.MatchString(wh.Code):
+		v.Add("code", "may only contain letters, digits, '.', '-' and '_', starting with a letter or digit")
+	}
+	if wh.Name == "" {
+		v.Add("name", "must not be empty")
+	}
+	if wh.Priority < 0 {
+		v.Add("priority", "must not be negative")
+	}
+	if wh.IsDefault && !wh.Active {
+		v.Add("active", "must be true for the default warehouse")
+	}
+
+	return v.Err()
+}
+
+// WarehouseStock is the stock of a product kept in one warehouse. Reserved
+// is how much of it reservations hold there.
+type WarehouseStock struct {
+	WarehouseID int    `json:"warehouseId"`
+	Code        string `json:"code"`
+	Quantity    int    `json:"quantity"`
+	Reserved    int    `json:"reserved"`
+}
+
+// WarehouseChange is the part of a movement that fell on one warehouse.
+type WarehouseChange struct {
+	WarehouseID   int `json:"warehouseId"`
+	Change        int `json:"change"`
+	QuantityAfter int `json:"quantityAfter"`
+}
+
+// AllocationItem is a quantity of a product to be shipped.
+type AllocationItem struct {
+	ProductID int `json:"productId"`
+	Quantity  int `json:"quantity"`
+}
+
+// AllocationRequest asks which warehouses an order should ship from.
+type AllocationRequest struct {
+	Items    []AllocationItem `json:"items"`
+	Strategy string           `json:"strategy"`
+	Region   string           `json:"region"`
+}
+
+// Validate checks the request on its own, defaulting its strategy.
+func (req *AllocationRequest) Validate() error {
+	var v ValidationError
+
+	if len(req.Items) == 0 {
+		v.Add("items", "must not be empty")
+	}
+	for i, item := range req.Items {
+		if item.Quantity < 1 {
+			v.Add(fmt.Sprintf("items[%d].quantity", i), "must be at least 1")
+		}
+	}
+
+	if req.Strategy == "" {
+		req.Strategy = AllocateByPriority
+	}
+	switch req.Strategy {
+	case AllocateByPriority:
+	case AllocateByNearest:
+		if req.Region == "" {
+			v.Add("region", "is required to allocate by nearest")
+		}
+	default:
+		v.Add("strategy", fmt.Sprintf("must be %s or %s", AllocateByPriority, AllocateByNearest))
+	}
+
+	return v.Err()
+}
+
+// Shipment is the part of an order one warehouse ships.
+type Shipment struct {
+	WarehouseID int              `json:"warehouseId"`
+	Code        string           `json:"code"`
+	Region      string           `json:"region"`
+	Items       []AllocationItem `json:"items"`
+}
+
+// Allocation is the warehouses picked to fulfil an order. Split is set when
+// no single warehouse could ship the whole order.
+type Allocation struct {
+	Shipments []*Shipment `json:"shipments"`
+	Split     bool        `json:"split"`
+}
+
+type WarehouseModel struct {
+	DB *sql.DB
+}
+
+const warehouseColumns = `warehouse_id, code, name, COALESCE(region, ''), priority, active, is_default, created_at, updated_at`
+
+func scanWarehouse(row rowScanner) (*Warehouse, error) {
+	var wh Warehouse
+
+	err := row.Scan(
+		&wh.ID,
+		&wh.Code,
+		&wh.Name,
+		&wh.Region,
+		&wh.Priority,
+		&wh.Active,
+		&wh.IsDefault,
+		&wh.CreatedAt,
+		&wh.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wh, nil
+}
+
+// GetAll returns every warehouse in order of priority.
+func (m *WarehouseModel) GetAll() ([]*Warehouse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+warehouseColumns+` FROM warehouses ORDER BY priority, warehouse_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warehouses := []*Warehouse{}
+
+	for rows.Next() {
+		wh, err := scanWarehouse(rows)
+		if err != nil {
+			return nil, err
+		}
+		warehouses = append(warehouses, wh)
+	}
+
+	return warehouses, rows.Err()
+}
+
+func (m *WarehouseModel) GetOne(id int) (*Warehouse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+warehouseColumns+` FROM warehouses WHERE warehouse_id = $1`, id)
+	return scanWarehouse(row)
+}
+
+// Insert creates a warehouse. Making it the default takes that role from
+// the current default.
+func (m *WarehouseModel) Insert(wh Warehouse) (*Warehouse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if wh.IsDefault {
+		_, err = tx.ExecContext(ctx, `UPDATE warehouses SET is_default = false, updated_at = $1 WHERE is_default`, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+		INSERT INTO warehouses (code, name, region, priority, active, is_default, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		RETURNING ` + warehouseColumns
+
+	saved, err := scanWarehouse(tx.QueryRowContext(ctx, query,
+		wh.Code,
+		wh.Name,
+		sql.NullString{String: wh.Region, Valid: wh.Region != ""},
+		wh.Priority,
+		wh.Active,
+		wh.IsDefault,
+		time.Now(),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, tx.Commit()
+}
+
+// Update saves a warehouse. Making it the default takes that role from the
+// current default; the default itself cannot be unset or deactivated, only
+// replaced, and returns ErrDefaultWarehouse.
+func (m *WarehouseModel) Update(wh Warehouse) (*Warehouse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRowContext(ctx, `SELECT is_default FROM warehouses WHERE warehouse_id = $1 FOR UPDATE`, wh.ID).Scan(&wasDefault)
+	if err != nil {
+		return nil, err
+	}
+	if wasDefault && !wh.IsDefault {
+		return nil, fmt.Errorf("%w: make another warehouse the default instead", ErrDefaultWarehouse)
+	}
+
+	if wh.IsDefault && !wasDefault {
+		_, err = tx.ExecContext(ctx, `UPDATE warehouses SET is_default = false, updated_at = $1 WHERE is_default`, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+		UPDATE warehouses
+		SET code = $1, name = $2, region = $3, priority = $4, active = $5, is_default = $6, updated_at = $7
+		WHERE warehouse_id = $8
+		RETURNING ` + warehouseColumns
+
+	saved, err := scanWarehouse(tx.QueryRowContext(ctx, query,
+		wh.Code,
+		wh.Name,
+		sql.NullString{String: wh.Region, Valid: wh.Region != ""},
+		wh.Priority,
+		wh.Active,
+		wh.IsDefault,
+		time.Now(),
+		wh.ID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, tx.Commit()
+}
+
+// Stock returns the stock of a product in each warehouse that has held it.
+func (m *WarehouseModel) Stock(productID int) ([]WarehouseStock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return productWarehouseStock(ctx, m.DB, productID)
+}
+
+func productWarehouseStock(ctx context.Context, q queryer, productID int) ([]WarehouseStock, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT s.warehouse_id, w.code, s.quantity, s.reserved
+		FROM warehouse_stock s
+		JOIN warehouses w ON w.warehouse_id = s.warehouse_id
+		WHERE s.product_id = $1
+		ORDER BY w.priority, w.warehouse_id
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := []WarehouseStock{}
+
+	for rows.Next() {
+		var s WarehouseStock
+		err := rows.Scan(&s.WarehouseID, &s.Code, &s.Quantity, &s.Reserved)
+		if err != nil {
+			return nil, err
+		}
+		stock = append(stock, s)
+	}
+
+	return stock, rows.Err()
+}
+
+// Allocate picks the active warehouses to ship an order from, without
+// holding any stock. Only stock that no reservation holds in a warehouse is
+// counted. A single warehouse that can ship the whole order is preferred, in
+// the order the strategy tries them; otherwise the order is split, each
+// warehouse in turn shipping what it can. It returns ErrInsufficientStock if
+// a product does not have enough available stock, counting what open
+// reservations hold, or the active warehouses cannot cover it between them.
+func (m *WarehouseModel) Allocate(req AllocationRequest) (*Allocation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := req.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// The same product asked for twice is allocated as one item
+	var items []AllocationItem
+	index := map[int]int{}
+	for _, item := range req.Items {
+		if i, ok := index[item.ProductID]; ok {
+			items[i].Quantity += item.Quantity
+			continue
+		}
+		index[item.ProductID] = len(items)
+		items = append(items, item)
+	}
+
+	ids := make([]int32, len(items))
+	for i, item := range items {
+		ids[i] = int32(item.ProductID)
+	}
+
+	var idArray pgtype.Int4Array
+	err = idArray.Set(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT product_id, COALESCE(quantity, 0) - reserved
+		FROM products
+		WHERE product_id = ANY($1) AND deleted_at IS NULL
+	`, &idArray)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	available := map[int]int{}
+	for rows.Next() {
+		var id, n int
+		err := rows.Scan(&id, &n)
+		if err != nil {
+			return nil, err
+		}
+		available[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		n, ok := available[item.ProductID]
+		if !ok {
+			return nil, fmt.Errorf("product %d: %w", item.ProductID, sql.ErrNoRows)
+		}
+		if n < item.Quantity {
+			return nil, fmt.Errorf("%w for product %d", ErrInsufficientStock, item.ProductID)
+		}
+	}
+
+	rows, err = m.DB.QueryContext(ctx, `
+		SELECT w.warehouse_id, w.code, COALESCE(w.region, ''), s.product_id, s.quantity - s.reserved
+		FROM warehouses w
+		JOIN warehouse_stock s ON s.warehouse_id = w.warehouse_id
+		WHERE w.active AND s.product_id = ANY($1) AND s.quantity > s.reserved
+		ORDER BY COALESCE(lower(w.region) = lower($2), false) DESC, w.priority, w.warehouse_id
+	`, &idArray, sql.NullString{String: req.Region, Valid: req.Strategy == AllocateByNearest})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []*candidateWarehouse
+	byID := map[int]*candidateWarehouse{}
+
+	for rows.Next() {
+		var wh Shipment
+		var productID, quantity int
+		err := rows.Scan(&wh.WarehouseID, &wh.Code, &wh.Region, &productID, &quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		c, ok := byID[wh.WarehouseID]
+		if !ok {
+			c = &candidateWarehouse{shipment: wh, stock: map[int]int{}}
+			byID[wh.WarehouseID] = c
+			candidates = append(candidates, c)
+		}
+		c.stock[productID] = quantity
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return allocate(items, candidates)
+}
+
+// candidateWarehouse is a warehouse an order may ship from, with the stock
+// of the products ordered that is not held there.
+type candidateWarehouse struct {
+	shipment Shipment
+	stock    map[int]int
+}
+
+// allocate picks warehouses from candidates, which are in the order they
+// should be tried, to ship items.
+func allocate(items []AllocationItem, candidates []*candidateWarehouse) (*Allocation, error) {
+	for _, c := range candidates {
+		whole := true
+		for _, item := range items {
+			if c.stock[item.ProductID] < item.Quantity {
+				whole = false
+				break
+			}
+		}
+		if whole {
+			s := c.shipment
+			s.Items = items
+			return &Allocation{Shipments: []*Shipment{&s}}, nil
+		}
+	}
+
+	remaining := map[int]int{}
+	for _, item := range items {
+		remaining[item.ProductID] = item.Quantity
+	}
+
+	allocation := &Allocation{Shipments: []*Shipment{}, Split: true}
+
+	for _, c := range candidates {
+		s := c.shipment
+		for _, item := range items {
+			n := min(remaining[item.ProductID], c.stock[item.ProductID])
+			if n > 0 {
+				s.Items = append(s.Items, AllocationItem{ProductID: item.ProductID, Quantity: n})
+				remaining[item.ProductID] -= n
+			}
+		}
+		if len(s.Items) > 0 {
+			allocation.Shipments = append(allocation.Shipments, &s)
+		}
+	}
+
+	for _, item := range items {
+		if remaining[item.ProductID] > 0 {
+			return nil, fmt.Errorf("%w across active warehouses for product %d", ErrInsufficientStock, item.ProductID)
+		}
+	}
+
+	return allocation, nil
+}
+
+// applyWarehouseChanges spreads a movement of a product's stock over its
+// warehouses, setting mv.Warehouses. A movement naming a warehouse falls
+// wholly on it. Otherwise stock is received into the default warehouse,
+// and taken from the warehouses keeping it in order of priority, leaving
+// what reservations hold there. It returns ErrInsufficientStock if a
+// warehouse would be left with less than it holds.
+func applyWarehouseChanges(ctx context.Context, tx *sql.Tx, mv *InventoryMovement) error {
+	if mv.WarehouseID != nil {
+		after, err := changeWarehouseStock(ctx, tx, *mv.WarehouseID, mv.ProductID, mv.Change, mv.CreatedAt)
+		if err != nil {
+			return err
+		}
+		mv.Warehouses = []WarehouseChange{{WarehouseID: *mv.WarehouseID, Change: mv.Change, QuantityAfter: after}}
+		return nil
+	}
+
+	if mv.Change > 0 {
+		var id int
+		err := tx.QueryRowContext(ctx, `SELECT warehouse_id FROM warehouses WHERE is_default`).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: there is none", ErrDefaultWarehouse)
+		}
+		if err != nil {
+			return err
+		}
+
+		after, err := changeWarehouseStock(ctx, tx, id, mv.ProductID, mv.Change, mv.CreatedAt)
+		if err != nil {
+			return err
+		}
+		mv.Warehouses = []WarehouseChange{{WarehouseID: id, Change: mv.Change, QuantityAfter: after}}
+		return nil
+	}
+
+	stock, err := productWarehouseStock(ctx, tx, mv.ProductID)
+	if err != nil {
+		return err
+	}
+
+	mv.Warehouses = nil
+	remaining := -mv.Change
+
+	for _, s := range stock {
+		n := min(remaining, s.Quantity-s.Reserved)
+		if n <= 0 {
+			continue
+		}
+
+		after, err := changeWarehouseStock(ctx, tx, s.WarehouseID, mv.ProductID, -n, mv.CreatedAt)
+		if err != nil {
+			return err
+		}
+		mv.Warehouses = append(mv.Warehouses, WarehouseChange{WarehouseID: s.WarehouseID, Change: -n, QuantityAfter: after})
+
+		remaining -= n
+		if remaining == 0 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w across warehouses for product %d", ErrInsufficientStock, mv.ProductID)
+}
+
+// changeWarehouseStock adds change to the stock of a product in a warehouse
+// and returns the new quantity. Stock reservations hold there cannot be
+// taken.
+func changeWarehouseStock(ctx context.Context, tx *sql.Tx, warehouseID, productID, change int, now time.Time) (int, error) {
+	var after int
+
+	if change > 0 {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO warehouse_stock (warehouse_id, product_id, quantity, updated_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (warehouse_id, product_id)
+			DO UPDATE SET quantity = warehouse_stock.quantity + EXCLUDED.quantity, updated_at = EXCLUDED.updated_at
+			RETURNING quantity
+		`, warehouseID, productID, change, now).Scan(&after)
+		return after, err
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		UPDATE warehouse_stock
+		SET quantity = quantity + $1, updated_at = $2
+		WHERE warehouse_id = $3 AND product_id = $4 AND quantity - reserved + $1 >= 0
+		RETURNING quantity
+	`, change, now, warehouseID, productID).Scan(&after)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM warehouses WHERE warehouse_id = $1)`, warehouseID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, fmt.Errorf("warehouse %d: %w", warehouseID, sql.ErrNoRows)
+		}
+		return 0, fmt.Errorf("%w in warehouse %d", ErrInsufficientStock, warehouseID)
+	}
+
+	return after, err
+}
+
+// changeWarehouseReserved adds change to how much of a product reservations
+// hold in a warehouse. Stock is only held in an active warehouse, and only
+// while enough of it is not held already.
+func changeWarehouseReserved(ctx context.Context, tx *sql.Tx, warehouseID, productID, change int, now time.Time) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE warehouse_stock s
+		SET reserved = s.reserved + $1, updated_at = $2
+		FROM warehouses w
+		WHERE s.warehouse_id = $3 AND s.product_id = $4 AND w.warehouse_id = s.warehouse_id
+		  AND (w.active OR $1 < 0) AND s.quantity - s.reserved >= $1
+	`, change, now, warehouseID, productID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM warehouses WHERE warehouse_id = $1)`, warehouseID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("warehouse %d: %w", warehouseID, sql.ErrNoRows)
+	}
+	return fmt.Errorf("%w in warehouse %d for product %d", ErrInsufficientStock, warehouseID, productID)
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func candidate(id int, stock map[int]int) *candidateWarehouse {
+	return &candidateWarehouse{
+		shipment: Shipment{WarehouseID: id, Code: fmt.Sprintf("W%d", id)},
+		stock:    stock,
+	}
+}
+
+// shipped describes an allocation as warehouse:product=quantity lists.
+func shipped(a *Allocation) string {
+	s := ""
+	for _, sh := range a.Shipments {
+		s += fmt.Sprintf("%d:", sh.WarehouseID)
+		for _, item := range sh.Items {
+			s += fmt.Sprintf("%d=%d,", item.ProductID, item.Quantity)
+		}
+		s += " "
+	}
+	return s
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name       string
+		items      []AllocationItem
+		candidates []*candidateWarehouse
+		want       string
+		wantSplit  bool
+		wantErr    error
+	}{
+		{
+			name:       "first warehouse ships everything",
+			items:      []AllocationItem{{1, 2}, {2, 1}},
+			candidates: []*candidateWarehouse{candidate(10, map[int]int{1: 5, 2: 5}), candidate(20, map[int]int{1: 5, 2: 5})},
+			want:       "10:1=2,2=1, ",
+		},
+		{
+			name:       "later warehouse ships everything rather than split",
+			items:      []AllocationItem{{1, 2}, {2, 1}},
+			candidates: []*candidateWarehouse{candidate(10, map[int]int{1: 5}), candidate(20, map[int]int{1: 2, 2: 1})},
+			want:       "20:1=2,2=1, ",
+		},
+		{
+			name:       "split in order",
+			items:      []AllocationItem{{1, 4}, {2, 1}},
+			candidates: []*candidateWarehouse{candidate(10, map[int]int{1: 3}), candidate(20, map[int]int{1: 3, 2: 1})},
+			want:       "10:1=3, 20:1=1,2=1, ",
+			wantSplit:  true,
+		},
+		{
+			name:       "warehouse with nothing needed is left out",
+			items:      []AllocationItem{{1, 4}},
+			candidates: []*candidateWarehouse{candidate(10, map[int]int{1: 3}), candidate(20, map[int]int{2: 9}), candidate(30, map[int]int{1: 3})},
+			want:       "10:1=3, 30:1=1, ",
+			wantSplit:  true,
+		},
+		{
+			name:       "not enough across warehouses",
+			items:      []AllocationItem{{1, 7}},
+			candidates: []*candidateWarehouse{candidate(10, map[int]int{1: 3}), candidate(20, map[int]int{1: 3})},
+			wantErr:    ErrInsufficientStock,
+		},
+		{
+			name:    "no warehouses",
+			items:   []AllocationItem{{1, 1}},
+			wantErr: ErrInsufficientStock,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := allocate(tt.items, tt.candidates)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("allocate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if s := shipped(got); s != tt.want {
+				t.Errorf("allocate() shipped %q, want %q", s, tt.want)
+			}
+			if got.Split != tt.wantSplit {
+				t.Errorf("allocate() split = %v, want %v", got.Split, tt.wantSplit)
+			}
+		})
+	}
+}
+
+func TestAllocationRequestValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		req          AllocationRequest
+		wantStrategy string
+		wantErr      bool
+	}{
+		{"defaults to priority", AllocationRequest{Items: []AllocationItem{{1, 1}}}, AllocateByPriority, false},
+		{"nearest with region", AllocationRequest{Items: []AllocationItem{{1, 1}}, Strategy: AllocateByNearest, Region: "eu-west"}, AllocateByNearest, false},
+		{"nearest without region", AllocationRequest{Items: []AllocationItem{{1, 1}}, Strategy: AllocateByNearest}, "", true},
+		{"unknown strategy", AllocationRequest{Items: []AllocationItem{{1, 1}}, Strategy: "cheapest"}, "", true},
+		{"no items", AllocationRequest{}, "", true},
+		{"zero quantity", AllocationRequest{Items: []AllocationItem{{1, 0}}}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalid) {
+				t.Errorf("Validate() error = %v, want it to wrap ErrInvalid", err)
+			}
+			if !tt.wantErr && tt.req.Strategy != tt.wantStrategy {
+				t.Errorf("Strategy = %q, want %q", tt.req.Strategy, tt.wantStrategy)
+			}
+		})
+	}
+}
+
+func TestMergeReservationItems(t *testing.T) {
+	id := func(n int) *int { return &n }
+
+	items := []ReservationItem{
+		{ProductID: 2, Quantity: 1},
+		{ProductID: 1, WarehouseID: id(20), Quantity: 2},
+		{ProductID: 1, Quantity: 1},
+		{ProductID: 1, WarehouseID: id(10), Quantity: 3},
+		{ProductID: 1, WarehouseID: id(20), Quantity: 4},
+		{ProductID: 2, Quantity: 5},
+	}
+
+	got, err := mergeReservationItems(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ product, warehouse, quantity int }{
+		{1, 0, 1},
+		{1, 10, 3},
+		{1, 20, 6},
+		{2, 0, 6},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("merged %d items, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.ProductID != w.product || idOrder(g.WarehouseID) != w.warehouse || g.Quantity != w.quantity {
+			t.Errorf("item %d = product %d warehouse %d quantity %d, want %v", i, g.ProductID, idOrder(g.WarehouseID), g.Quantity, w)
+		}
+	}
+
+	_, err = mergeReservationItems([]ReservationItem{{ProductID: 1, Quantity: 0}})
+	if err == nil {
+		t.Error("mergeReservationItems() accepted a zero quantity")
+	}
+}
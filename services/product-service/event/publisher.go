@@ -0,0 +1,54 @@
+package event
+
+import (
+	"log"
+	"sync"
+)
+
+// Publisher sends a message body to a named queue.
+type Publisher interface {
+	Publish(queue string, body []byte) error
+}
+
+// LogPublisher stands in for a broker when none is configured. It logs every
+// message and drops it.
+type LogPublisher struct{}
+
+func (LogPublisher) Publish(queue string, body []byte) error {
+	log.Printf("No broker configured, dropping message for %s: %s\n", queue, body)
+	return nil
+}
+
+// Message is a message held by a MemoryPublisher.
+type Message struct {
+	Queue string
+	Body  []byte
+}
+
+// MemoryPublisher keeps published messages in memory, for running the relay
+// without a broker. Set Err to make Publish fail.
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []Message
+	Err      error
+}
+
+func (p *MemoryPublisher) Publish(queue string, body []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Err != nil {
+		return p.Err
+	}
+
+	p.messages = append(p.messages, Message{Queue: queue, Body: body})
+	return nil
+}
+
+// Messages returns a copy of everything published so far, in order.
+func (p *MemoryPublisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Message(nil), p.messages...)
+}
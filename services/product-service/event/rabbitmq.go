@@ -0,0 +1,113 @@
+package event
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMQ publishes messages to durable queues on a RabbitMQ broker. If the
+// connection drops, the next Publish dials the broker again.
+type RabbitMQ struct {
+	url     string
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	queues  map[string]bool
+}
+
+// Connect dials the broker at url, retrying while it starts up.
+func Connect(url string) (*RabbitMQ, error) {
+	r := &RabbitMQ{url: url}
+	counts := 0
+
+	for {
+		err := r.dial()
+		if err == nil {
+			log.Println("Connected to RabbitMQ!")
+			return r, nil
+		}
+
+		log.Println("RabbitMQ not yet ready...")
+		counts++
+
+		if counts > 10 {
+			log.Println(err)
+			return nil, err
+		}
+
+		log.Println("Backing off for two seconds...")
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func (r *RabbitMQ) dial() error {
+	conn, err := amqp.Dial(r.url)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	r.conn = conn
+	r.channel = ch
+	r.queues = map[string]bool{}
+
+	return nil
+}
+
+// Publish sends body as a persistent JSON message to the named queue,
+// declaring the queue the first time it is used.
+func (r *RabbitMQ) Publish(queue string, body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.channel.IsClosed() {
+		r.conn.Close()
+		err := r.dial()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !r.queues[queue] {
+		_, err := r.channel.QueueDeclare(
+			queue, // name
+			true,  // durable
+			false, // delete when unused
+			false, // exclusive
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return err
+		}
+		r.queues[queue] = true
+	}
+
+	return r.channel.Publish(
+		"",    // exchange
+		queue, // routing key
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		},
+	)
+}
+
+func (r *RabbitMQ) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.channel.Close()
+	r.conn.Close()
+}
@@ -0,0 +1,101 @@
+package event
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"product/data"
+)
+
+// Outbox is the store the relay reads events from. It is implemented by
+// data.OutboxModel.
+type Outbox interface {
+	Claim(limit int, lease time.Duration) ([]data.OutboxMessage, error)
+	MarkPublished(id int64) error
+	MarkFailed(id int64, delay time.Duration, cause error) error
+}
+
+// Relay publishes events written to the outbox. Delivery is at least once: a
+// message is only marked published after the publisher accepts it, so a
+// crash in between, or a lease that runs out first, publishes it again.
+type Relay struct {
+	Outbox    Outbox
+	Publisher Publisher
+
+	// Interval is how long the relay waits once the outbox is drained.
+	Interval time.Duration
+	// BatchSize is the most messages claimed at a time.
+	BatchSize int
+	// Lease is how long claimed messages are kept from other relays while
+	// they are published.
+	Lease time.Duration
+	// MinBackoff and MaxBackoff bound the exponential delay before a message
+	// that failed to publish is retried.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func NewRelay(outbox Outbox, publisher Publisher) *Relay {
+	return &Relay{
+		Outbox:     outbox,
+		Publisher:  publisher,
+		Interval:   time.Second,
+		BatchSize:  100,
+		Lease:      time.Minute,
+		MinBackoff: time.Second,
+		MaxBackoff: 5 * time.Minute,
+	}
+}
+
+// Run relays events until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		_, err := r.Drain()
+		if err != nil {
+			log.Println("Error relaying outbox:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+// Drain publishes batches until no due message is left, and returns how many
+// messages it published.
+func (r *Relay) Drain() (int, error) {
+	total := 0
+
+	for {
+		messages, err := r.Outbox.Claim(r.BatchSize, r.Lease)
+		if err != nil || len(messages) == 0 {
+			return total, err
+		}
+
+		for _, msg := range messages {
+			pubErr := r.Publisher.Publish(msg.Destination, msg.Payload)
+			if pubErr != nil {
+				err = r.Outbox.MarkFailed(msg.ID, r.Backoff(msg.Attempts+1), pubErr)
+			} else {
+				err = r.Outbox.MarkPublished(msg.ID)
+				total++
+			}
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+}
+
+// Backoff returns the delay before the given attempt is retried: MinBackoff
+// doubled for every earlier failure, capped at MaxBackoff.
+func (r *Relay) Backoff(attempts int) time.Duration {
+	d := r.MinBackoff
+	for i := 1; i < attempts && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, r.MaxBackoff)
+}
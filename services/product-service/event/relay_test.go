@@ -0,0 +1,283 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"product/data"
+)
+
+// memoryOutbox is an outbox held in memory that hands out messages the way
+// data.OutboxModel does: due heads of each aggregate, oldest first, leased
+// until claimed again. now is its clock.
+type memoryOutbox struct {
+	now      time.Time
+	messages []*outboxEntry
+
+	// failMark makes that many MarkPublished calls fail, as a crash between
+	// publishing and recording it would.
+	failMark int
+}
+
+type outboxEntry struct {
+	msg       data.OutboxMessage
+	due       time.Time
+	published bool
+}
+
+func newMemoryOutbox() *memoryOutbox {
+	return &memoryOutbox{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (o *memoryOutbox) add(aggregateID, body string) {
+	id := int64(len(o.messages) + 1)
+	o.messages = append(o.messages, &outboxEntry{
+		msg: data.OutboxMessage{
+			ID:            id,
+			AggregateType: data.AggregateProduct,
+			AggregateID:   aggregateID,
+			Destination:   "catalog",
+			Payload:       []byte(body),
+		},
+		due: o.now,
+	})
+}
+
+func (o *memoryOutbox) entry(id int64) *outboxEntry {
+	return o.messages[id-1]
+}
+
+func (o *memoryOutbox) Claim(limit int, lease time.Duration) ([]data.OutboxMessage, error) {
+	var claimed []data.OutboxMessage
+	blocked := map[string]bool{}
+
+	for _, e := range o.messages {
+		if e.published {
+			continue
+		}
+		if blocked[e.msg.AggregateID] {
+			continue
+		}
+		blocked[e.msg.AggregateID] = true
+
+		if e.due.After(o.now) || len(claimed) == limit {
+			continue
+		}
+		e.due = o.now.Add(lease)
+		claimed = append(claimed, e.msg)
+	}
+
+	return claimed, nil
+}
+
+func (o *memoryOutbox) MarkPublished(id int64) error {
+	if o.failMark > 0 {
+		o.failMark--
+		return errors.New("connection lost")
+	}
+	o.entry(id).published = true
+	return nil
+}
+
+func (o *memoryOutbox) MarkFailed(id int64, delay time.Duration, cause error) error {
+	e := o.entry(id)
+	e.msg.Attempts++
+	e.due = o.now.Add(delay)
+	return nil
+}
+
+func bodies(messages []Message) []string {
+	var s []string
+	for _, m := range messages {
+		s = append(s, string(m.Body))
+	}
+	return s
+}
+
+func newTestRelay(outbox *memoryOutbox, publisher Publisher) *Relay {
+	r := NewRelay(outbox, publisher)
+	r.BatchSize = 2
+	return r
+}
+
+func TestRelayKeepsOrderPerAggregate(t *testing.T) {
+	outbox := newMemoryOutbox()
+	outbox.add("1", "1a")
+	outbox.add("2", "2a")
+	outbox.add("1", "1b")
+	outbox.add("1", "1c")
+	outbox.add("2", "2b")
+	outbox.add("3", "3a")
+
+	publisher := &MemoryPublisher{}
+	n, err := newTestRelay(outbox, publisher).Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 {
+		t.Fatalf("Drain() published %d, want 6", n)
+	}
+
+	seen := map[string][]string{}
+	for _, b := range bodies(publisher.Messages()) {
+		seen[b[:1]] = append(seen[b[:1]], b)
+	}
+
+	want := map[string][]string{
+		"1": {"1a", "1b", "1c"},
+		"2": {"2a", "2b"},
+		"3": {"3a"},
+	}
+	for aggregate, w := range want {
+		if fmt.Sprint(seen[aggregate]) != fmt.Sprint(w) {
+			t.Errorf("aggregate %s published %v, want %v", aggregate, seen[aggregate], w)
+		}
+	}
+}
+
+func TestRelayRetriesWithBackoff(t *testing.T) {
+	outbox := newMemoryOutbox()
+	outbox.add("1", "1a")
+	outbox.add("1", "1b")
+	outbox.add("2", "2a")
+
+	publisher := &MemoryPublisher{Err: errors.New("broker down")}
+	relay := newTestRelay(outbox, publisher)
+
+	// Every attempt fails, and each waits twice as long as the one before
+	for attempt, wait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		n, err := relay.Drain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Fatalf("attempt %d: Drain() published %d, want 0", attempt+1, n)
+		}
+		if got := outbox.entry(1).msg.Attempts; got != attempt+1 {
+			t.Fatalf("attempt %d: attempts = %d, want %d", attempt+1, got, attempt+1)
+		}
+		if got := outbox.entry(1).due.Sub(outbox.now); got != wait {
+			t.Fatalf("attempt %d: retried after %v, want %v", attempt+1, got, wait)
+		}
+
+		// Nothing is due again before the backoff has passed
+		outbox.now = outbox.now.Add(wait - time.Millisecond)
+		n, _ = relay.Drain()
+		if n != 0 || outbox.entry(1).msg.Attempts != attempt+1 {
+			t.Fatalf("attempt %d: retried before the backoff passed", attempt+1)
+		}
+		outbox.now = outbox.now.Add(time.Millisecond)
+	}
+
+	publisher.Err = nil
+
+	n, err := relay.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("Drain() published %d, want 3", n)
+	}
+	if got := bodies(publisher.Messages()); fmt.Sprint(got) != "[1a 2a 1b]" {
+		t.Errorf("published %v, want [1a 2a 1b]", got)
+	}
+}
+
+func TestRelayFailureHoldsBackAggregate(t *testing.T) {
+	outbox := newMemoryOutbox()
+	outbox.add("1", "1a")
+	outbox.add("1", "1b")
+	outbox.add("2", "2a")
+
+	publisher := &failingPublisher{fail: map[string]bool{"1a": true}}
+	n, err := newTestRelay(outbox, publisher).Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("Drain() published %d, want 1", n)
+	}
+	if fmt.Sprint(publisher.published) != "[2a]" {
+		t.Errorf("published %v, want [2a]", publisher.published)
+	}
+}
+
+func TestRelayDeliversAtLeastOnce(t *testing.T) {
+	outbox := newMemoryOutbox()
+	outbox.add("1", "1a")
+	outbox.add("1", "1b")
+	outbox.failMark = 1
+
+	publisher := &MemoryPublisher{}
+	relay := newTestRelay(outbox, publisher)
+
+	// The broker takes the message but recording that fails, so it stays
+	// unpublished and leased
+	_, err := relay.Drain()
+	if err == nil {
+		t.Fatal("Drain() error = nil, want the failure to mark the message")
+	}
+	if outbox.entry(1).published {
+		t.Fatal("message marked published after marking it failed")
+	}
+
+	// While the lease holds, no other relay publishes it
+	n, err := relay.Drain()
+	if err != nil || n != 0 {
+		t.Fatalf("Drain() = %d, %v within the lease, want 0, nil", n, err)
+	}
+
+	// Once the lease runs out it is published again, and only then does
+	// the next message of the aggregate follow
+	outbox.now = outbox.now.Add(relay.Lease)
+	n, err = relay.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Drain() published %d, want 2", n)
+	}
+	if got := bodies(publisher.Messages()); fmt.Sprint(got) != "[1a 1a 1b]" {
+		t.Errorf("published %v, want [1a 1a 1b]", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := &Relay{MinBackoff: time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempts), func(t *testing.T) {
+			if got := r.Backoff(tt.attempts); got != tt.want {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+// failingPublisher fails to publish the bodies in fail.
+type failingPublisher struct {
+	fail      map[string]bool
+	published []string
+}
+
+func (p *failingPublisher) Publish(queue string, body []byte) error {
+	if p.fail[string(body)] {
+		return errors.New("rejected")
+	}
+	p.published = append(p.published, string(body))
+	return nil
+}
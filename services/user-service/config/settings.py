@@ -62,6 +62,14 @@ REST_FRAMEWORK = {
     'DEFAULT_SCHEMA_CLASS': 'drf_spectacular.openapi.AutoSchema',
 }
 
+# Tokens carry the issuer and audience product-service checks, and the roles
+# added by CatalogTokenObtainPairSerializer
+SIMPLE_JWT = {
+    'ISSUER': env('JWT_ISSUER', default='user-service'),
+    'AUDIENCE': env('JWT_AUDIENCE', default='ecommerce'),
+    'TOKEN_OBTAIN_SERIALIZER': 'users.serializers.CatalogTokenObtainPairSerializer',
+}
+
 SPECTACULAR_SETTINGS = {
     'TITLE': 'User Service API',
     'DESCRIPTION': 'API for User Management and Authentication',
@@ -14,6 +14,8 @@ class User(AbstractUser):
         choices=Role.choices,
         default=Role.USER
     )
+    # Price lists in product-service can be limited to a customer group.
+    customer_group = models.CharField(max_length=50, blank=True, null=True)
 
     REQUIRED_FIELDS = ['email', 'first_name', 'last_name']
     
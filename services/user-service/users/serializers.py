@@ -1,9 +1,19 @@
 from rest_framework import serializers
 from django.contrib.auth import get_user_model
+from rest_framework_simplejwt.serializers import TokenObtainPairSerializer
 from .models import Address
 
 User = get_user_model()
 
+# Roles granted to admins in the tokens they obtain. product-service requires
+# them for writes to the catalog and to inventory.
+ADMIN_ROLES = ['catalog:write', 'inventory:write']
+
+def user_roles(user):
+    if user.role == User.Role.ADMIN or user.is_staff or user.is_superuser:
+        return list(ADMIN_ROLES)
+    return []
+
 class AddressSerializer(serializers.ModelSerializer):
     class Meta:
         model = Address
@@ -14,7 +24,8 @@ class UserSerializer(serializers.ModelSerializer):
 
     class Meta:
         model = User
-        fields = ['id', 'username', 'email', 'first_name', 'last_name', 'phone', 'image_url', 'role', 'addresses']
+        fields = ['id', 'username', 'email', 'first_name', 'last_name', 'phone', 'image_url', 'role', 'customer_group', 'addresses']
+        read_only_fields = ['customer_group']
 
 class UserRegistrationSerializer(serializers.ModelSerializer):
     confirm_password = serializers.CharField(write_only=True)
@@ -34,3 +45,15 @@ class UserRegistrationSerializer(serializers.ModelSerializer):
     def create(self, validated_data):
         validated_data.pop('confirm_password')
         return User.objects.create_user(**validated_data)
+
+class CatalogTokenObtainPairSerializer(TokenObtainPairSerializer):
+    """Adds the roles and customer group other services check to the tokens
+    a user obtains."""
+
+    @classmethod
+    def get_token(cls, user):
+        token = super().get_token(user)
+        token['roles'] = user_roles(user)
+        if user.customer_group:
+            token['customer_group'] = user.customer_group
+        return token
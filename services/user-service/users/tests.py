@@ -1,6 +1,6 @@
 from django.test import TestCase
 from django.contrib.auth import get_user_model
-from .serializers import UserRegistrationSerializer
+from .serializers import UserRegistrationSerializer, CatalogTokenObtainPairSerializer
 
 from rest_framework.test import APIClient
 from django.urls import reverse
@@ -32,6 +32,25 @@ class AuthTests(TestCase):
         response = self.client.post(self.verify_url, {'token': 'invalidtoken'})
         self.assertEqual(response.status_code, 401)
 
+class TokenClaimsTests(TestCase):
+    def test_admin_token_carries_roles(self):
+        admin = User.objects.create_user(username='admin', password='pw', email='admin@example.com', role=User.Role.ADMIN)
+        token = CatalogTokenObtainPairSerializer.get_token(admin)
+        self.assertEqual(token['roles'], ['catalog:write', 'inventory:write'])
+        self.assertEqual(token.access_token['roles'], ['catalog:write', 'inventory:write'])
+        self.assertEqual(token.access_token['token_type'], 'access')
+
+    def test_user_token_carries_no_roles(self):
+        user = User.objects.create_user(username='plain', password='pw', email='plain@example.com')
+        token = CatalogTokenObtainPairSerializer.get_token(user)
+        self.assertEqual(token.access_token['roles'], [])
+        self.assertNotIn('customer_group', token.access_token)
+
+    def test_token_carries_customer_group(self):
+        user = User.objects.create_user(username='trade', password='pw', email='trade@example.com', customer_group='wholesale')
+        token = CatalogTokenObtainPairSerializer.get_token(user)
+        self.assertEqual(token.access_token['customer_group'], 'wholesale')
+
 class SerializerTests(TestCase):
     def test_registration_serializer_success(self):
         data = {